@@ -0,0 +1,47 @@
+#!/bin/bash
+
+set -e
+
+echo ""
+echo "--- BENCH ECHO LT/ET START ---"
+echo ""
+
+cd $(dirname "${BASH_SOURCE[0]}")
+function cleanup {
+    echo "--- BENCH ECHO LT/ET DONE ---"
+    kill -9 $(jobs -rp)
+    wait $(jobs -rp) 2>/dev/null
+}
+trap cleanup EXIT
+
+mkdir -p bin
+$(pkill -9 zput_net_golang-echo-server || printf "")
+
+go build -o bin/zput_net_golang-echo-server ../example/echo/echo.go
+
+# $1 名称, $2 端口, $3 loops, $4 是否边沿触发, $5 说明, $6 报文
+function gobench {
+    echo "--- $1 ---"
+    GOMAXPROCS=1 bin/zput_net_golang-echo-server --port $2 --loops $3 --et=$4 &
+
+    sleep 1
+    echo "*** 50 connections, 10 seconds, $5"
+    nl=$'\r\n'
+    tcpkali --workers 1 -c 50 -T 10s -m "$6{$nl}" 127.0.0.1:$2
+    echo "--- DONE ---"
+    kill -9 $(jobs -rp)
+    wait $(jobs -rp) 2>/dev/null || printf ""
+    echo ""
+}
+
+PING="PING"
+BIG=$(head -c 16384 < /dev/zero | tr '\0' 'x')
+
+gobench "LT 1 loop"  58121 1 false "6 byte packets"   $PING
+gobench "ET 1 loop"  58122 1 true  "6 byte packets"   $PING
+gobench "LT 4 loops" 58123 4 false "6 byte packets"   $PING
+gobench "ET 4 loops" 58124 4 true  "6 byte packets"   $PING
+gobench "LT 1 loop"  58125 1 false "16KiB packets"    $BIG
+gobench "ET 1 loop"  58126 1 true  "16KiB packets"    $BIG
+gobench "LT 4 loops" 58127 4 false "16KiB packets"    $BIG
+gobench "ET 4 loops" 58128 4 true  "16KiB packets"    $BIG
@@ -46,9 +46,11 @@ func main() {
 	handler := new(Echo)
 	var port int
 	var loops int
+	var edgeTriggered bool
 
 	flag.IntVar(&port, "port", 58810, "server port")
 	flag.IntVar(&loops, "loops", 1, "num loops")
+	flag.BoolVar(&edgeTriggered, "et", false, "edge-triggered sub loops")
 	flag.Parse()
 
 	log.Info("server begin")
@@ -56,7 +58,8 @@ func main() {
 	s, err := tcpserver.New(handler,
 		protocol.Network("tcp"),
 		protocol.Address(":"+strconv.Itoa(port)),
-		protocol.NumLoops(loops))
+		protocol.NumLoops(loops),
+		protocol.EdgeTriggered(edgeTriggered))
 	if err != nil {
 		panic(err)
 	}
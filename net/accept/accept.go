@@ -7,18 +7,48 @@ import (
 	"github.com/zput/zput_net_golang/net/protocol"
 	"net"
 	"os"
+	"strings"
+	"time"
 
 	reuseport "github.com/libp2p/go-reuseport"
 	"golang.org/x/sys/unix"
 )
 
-// Listener 监听TCP连接
+// acceptBackoff fd 用尽时暂停 accept 的时间, 连续失败时指数增长
+var acceptBackoff = protocol.Backoff{
+	Initial:    10 * time.Millisecond,
+	Max:        time.Second,
+	Multiplier: 2,
+}
+
+// defaultLogger 没有调用 SetLogger 时使用
+var defaultLogger = log.Default()
+
+// Listener 监听TCP/unix连接
 type Accept struct {
+	option                     protocol.NetWorkAndAddressAndOption
 	listener                   net.Listener
 	aCopyOfTheUnderlyingOsFile *os.File
+	fd                         int
 	loop                       *event_loop.EventLoop
 	newConnectCallback         protocol.OnNewConnectCallback
+	acceptErrorCallback        protocol.OnAcceptErrorCallback
 	event                      *event_loop.Event
+	logger                     *log.FieldLogger
+
+	// 每次可读事件最多 accept 的连接数
+	acceptBudget int
+	// 预留的 fd, EMFILE/ENFILE 时释放出来 accept 并立即关闭排队的连接
+	reserveFd int
+	// 连续 EMFILE/ENFILE 的次数, 决定暂停多久
+	tooManyFiles int64
+	// Pause/Resume 在调用时立即设置; Pause 在 accept 的回调中调用时, 同一批剩下的连接要留在 backlog 中,
+	// 不能等排队的任务执行
+	pauseRequested protocol.Bool
+	// 以下只在 loop 中访问
+	backoffPaused bool
+	paused        bool
+	closed        bool
 }
 
 // New 创建Listener
@@ -27,7 +57,7 @@ func New(option protocol.NetWorkAndAddressAndOption, loop *event_loop.EventLoop)
 		listener net.Listener
 		err error
 	)
-	if option.ReusePort {
+	if option.ReusePort && !isUnixNetwork(option.Network) {
 		listener, err = reuseport.Listen(option.Network, option.Address)
 	} else {
 		listener, err = net.Listen(option.Network, option.Address)
@@ -36,22 +66,34 @@ func New(option protocol.NetWorkAndAddressAndOption, loop *event_loop.EventLoop)
 		return nil, err
 	}
 	var tcpAccept = Accept{
+		option:   option,
 		listener: listener,
 		loop:     loop,
+		acceptBudget: protocol.DefaultAcceptBudget,
+		reserveFd: openReserveFd(),
 	}
 
 	//从listener中得到FD填充到TcpAccept.
 	err = tcpAccept.setFd()
 	if err != nil {
+		_ = listener.Close()
 		return nil, err
 	}
-	log.Debugf("created listen fd[%d]; in tcp accept", tcpAccept.Fd())
+	// File() 得到的 fd 是阻塞模式, 边沿触发时要一直 accept 到 EAGAIN
+	if err = tcpAccept.SetNonblock(); err != nil {
+		_ = listener.Close()
+		_ = tcpAccept.aCopyOfTheUnderlyingOsFile.Close()
+		tcpAccept.closeReserveFd()
+		return nil, err
+	}
+	tcpAccept.SetLogger(defaultLogger)
+	tcpAccept.logger.Debug("listen fd created")
 	//新建Tcp Accept event_loop.
 	tcpAccept.event = event_loop.NewEvent(loop, tcpAccept.Fd())
 	//将这个accept event添加到loop，给多路复用监听。
 	err = tcpAccept.event.Register()
 	if err != nil{
-		log.Error("creating tcpAccept failure; AddEvent; error[%v]", err)
+		tcpAccept.logger.Error("register listen event failed", log.Err(err))
 		return nil, err
 	}
 
@@ -60,40 +102,71 @@ func New(option protocol.NetWorkAndAddressAndOption, loop *event_loop.EventLoop)
 	return &tcpAccept, nil
 }
 
+// SetLogger 使用 Server 的 logger, 附加上监听的字段
+func (this *Accept) SetLogger(logger *log.FieldLogger) {
+	this.logger = logger.With(log.Fd(this.fd), log.F("listen", this.option.Address), log.Loop(this.loop.SequenceID))
+}
+
 func (this *Accept)Listen()error{
-	log.Debugf("enable reading; in tcp accept activity; FD(%d)", this.event.GetFd())
+	this.logger.Debug("start listening")
 	return this.event.EnableReading(true)
 }
 
 // Close Accept
 func (this *Accept) Close()error{
 	this.loop.RunInLoop(func() {
+		if this.closed {
+			return
+		}
+		this.closed = true
 		var err error
 		err = this.event.DisableAll()
 		if err != nil{
-			log.Errorf("close event_loop.DisableAll; error[%v]", err)
+			this.logger.Error("disable listen event failed", log.Err(err))
 		}
 		err = this.event.UnRegister()
 		if err != nil{
-			log.Errorf("close event_loop.RemoveFromLoop; error[%v]", err)
+			this.logger.Error("unregister listen event failed", log.Err(err))
 		}
 		if err := this.listener.Close(); err != nil {
-			log.Errorf("[Listener] close; error[%v] ", err)
+			this.logger.Error("close listener failed", log.Err(err))
 		}
+		// File() 得到的是 dup 出来的 fd, 不关闭的话内核里仍然在监听
+		if err := this.aCopyOfTheUnderlyingOsFile.Close(); err != nil {
+			this.logger.Error("close listener file failed", log.Err(err))
+		}
+		this.removeSocketFile()
+		this.closeReserveFd()
 	})
 	return nil
 }
 
+// removeSocketFile 删除 unix socket 文件, 抽象地址(@开头)没有文件
+func (this *Accept) removeSocketFile() {
+	if !isUnixNetwork(this.option.Network) || strings.HasPrefix(this.option.Address, "@") {
+		return
+	}
+	if err := os.Remove(this.option.Address); err != nil && !os.IsNotExist(err) {
+		this.logger.Error("remove socket file failed", log.Err(err))
+	}
+}
+
+func isUnixNetwork(network string) bool {
+	return network == "unix"
+}
+
 func (this *Accept) setFd() error {
-	tcpListener, ok := this.listener.(*net.TCPListener)
+	fileListener, ok := this.listener.(interface{ File() (*os.File, error) })
 	if !ok {
 		return errors.New("could not get file descriptor")
 	}
-	file, err := tcpListener.File()
+	file, err := fileListener.File()
 	if err != nil {
 		return err
 	}
 	this.aCopyOfTheUnderlyingOsFile = file
+	// Fd() 每次调用都会把 fd 设置为阻塞模式, 只调用一次
+	this.fd = int(file.Fd())
 	return nil
 }
 
@@ -101,10 +174,21 @@ func (this *Accept) SetNewConnectCallback(newConnectCallback protocol.OnNewConne
 	this.newConnectCallback = newConnectCallback
 }
 
+func (this *Accept) SetAcceptErrorCallback(acceptErrorCallback protocol.OnAcceptErrorCallback) {
+	this.acceptErrorCallback = acceptErrorCallback
+}
+
+// SetAcceptBudget 每次可读事件最多 accept 的连接数, 需要在 Listen 之前调用
+func (this *Accept) SetAcceptBudget(acceptBudget int) {
+	if acceptBudget > 0 {
+		this.acceptBudget = acceptBudget
+	}
+}
+
 func (this *Accept) SetNonblock() error {
 	var err error
 	//设置非阻塞
-	if err = unix.SetNonblock(int(this.aCopyOfTheUnderlyingOsFile.Fd()), true); err != nil {
+	if err = unix.SetNonblock(this.fd, true); err != nil {
 		return err
 	}
 	return nil
@@ -112,18 +196,131 @@ func (this *Accept) SetNonblock() error {
 
 //AcceptHandle供event loop回调处理
 func (this *Accept) AcceptHandle() {
-	nfd, sa, err := unix.Accept(this.Fd())
-	if err != nil {
-		if err != unix.EAGAIN {
-			log.Error("accept:", err)
+	if this.closed || this.backoffPaused || this.paused {
+		return
+	}
+
+	for i := 0; i < this.acceptBudget; i++ {
+		nfd, sa, err := acceptNonblock(this.fd)
+		if err != nil {
+			switch err {
+			case unix.EAGAIN:
+				return
+			case unix.EINTR, unix.ECONNABORTED:
+				// 对端在 accept 之前已经断开, 继续处理下一个
+				continue
+			case unix.EMFILE, unix.ENFILE:
+				this.handleTooManyFiles(err)
+				return
+			default:
+				this.acceptError(err)
+				return
+			}
+		}
+		this.tooManyFiles = 0
+
+		this.newConnectCallback(nfd, sa)
+		if this.pauseRequested.Get() {
+			this.pauseInLoop()
+			return
 		}
+	}
+
+	// 用完了这次的额度; 边沿触发不会再通知, 留到下一轮继续 accept
+	if this.loop.IsEdgeTriggered() {
+		this.loop.RunInLoop(this.AcceptHandle)
+	}
+}
+
+func (this *Accept) acceptError(err error) {
+	this.logger.Error("accept failed", log.Err(err))
+	if this.acceptErrorCallback != nil {
+		this.acceptErrorCallback(err)
+	}
+}
+
+// handleTooManyFiles 进程或系统的 fd 用尽时, 排队的连接一直让 listen fd 可读;
+// 先用预留的 fd 把一个连接 accept 出来关闭, 再暂停 accept 一段时间等 fd 释放
+func (this *Accept) handleTooManyFiles(err error) {
+	this.acceptError(err)
+
+	if this.reserveFd >= 0 {
+		_ = unix.Close(this.reserveFd)
+		if nfd, _, err := unix.Accept(this.fd); err == nil {
+			_ = unix.Close(nfd)
+		}
+		this.reserveFd = openReserveFd()
+	}
+
+	this.tooManyFiles++
+	this.backoffPaused = true
+	if err := this.event.EnableReading(false); err != nil {
+		this.logger.Error("pause accept failed", log.Err(err))
+	}
+	time.AfterFunc(acceptBackoff.Next(this.tooManyFiles), func() {
+		this.loop.RunInLoop(this.resumeAfterBackoff)
+	})
+}
+
+func (this *Accept) resumeAfterBackoff() {
+	this.backoffPaused = false
+	this.enableAccept()
+}
+
+// Pause 暂停 accept, 新连接留在内核的 backlog 中; 可以在任意协程调用
+func (this *Accept) Pause() {
+	this.pauseRequested.Set(true)
+	this.loop.RunInLoop(this.pauseInLoop)
+}
+
+func (this *Accept) pauseInLoop() {
+	if this.paused || this.closed || !this.pauseRequested.Get() {
+		return
+	}
+	this.paused = true
+	if err := this.event.EnableReading(false); err != nil {
+		this.logger.Error("pause accept failed", log.Err(err))
+	}
+}
+
+// Resume 恢复 Pause 暂停的 accept; 可以在任意协程调用
+func (this *Accept) Resume() {
+	this.pauseRequested.Set(false)
+	this.loop.RunInLoop(func() {
+		if !this.paused || this.pauseRequested.Get() {
+			return
+		}
+		this.paused = false
+		this.enableAccept()
+	})
+}
+
+func (this *Accept) enableAccept() {
+	if this.closed || this.paused || this.backoffPaused {
 		return
 	}
+	if err := this.event.EnableReading(true); err != nil {
+		this.logger.Error("resume accept failed", log.Err(err))
+	}
+}
+
+func openReserveFd() int {
+	fd, err := unix.Open("/dev/null", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		defaultLogger.Error("open reserve fd failed", log.Err(err))
+		return -1
+	}
+	return fd
+}
 
-	this.newConnectCallback(nfd, sa)
+func (this *Accept) closeReserveFd() {
+	if this.reserveFd >= 0 {
+		_ = unix.Close(this.reserveFd)
+		this.reserveFd = -1
+	}
 }
 
 // Fd Accept fd
 func (this *Accept) Fd() int {
-	return int(this.aCopyOfTheUnderlyingOsFile.Fd())
+	return this.fd
 }
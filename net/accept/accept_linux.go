@@ -0,0 +1,10 @@
+// +build linux
+
+package accept
+
+import "golang.org/x/sys/unix"
+
+// acceptNonblock accept4 一次系统调用得到非阻塞、close-on-exec 的 fd
+func acceptNonblock(fd int) (int, unix.Sockaddr, error) {
+	return unix.Accept4(fd, unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC)
+}
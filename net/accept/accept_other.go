@@ -0,0 +1,19 @@
+// +build !linux
+
+package accept
+
+import "golang.org/x/sys/unix"
+
+// acceptNonblock 没有 accept4 的平台上 accept 之后再设置非阻塞和 close-on-exec
+func acceptNonblock(fd int) (int, unix.Sockaddr, error) {
+	nfd, sa, err := unix.Accept(fd)
+	if err != nil {
+		return -1, nil, err
+	}
+	unix.CloseOnExec(nfd)
+	if err = unix.SetNonblock(nfd, true); err != nil {
+		_ = unix.Close(nfd)
+		return -1, nil, err
+	}
+	return nfd, sa, nil
+}
@@ -0,0 +1,70 @@
+package accept
+
+import (
+	"github.com/zput/zput_net_golang/net/event_loop"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"golang.org/x/sys/unix"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAcceptTooManyFiles(t *testing.T) {
+	loop, err := event_loop.New(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(protocol.NetWorkAndAddressAndOption{Network: "tcp", Address: "127.0.0.1:0"}, loop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var acceptErrors []error
+	a.SetAcceptErrorCallback(func(err error) {
+		acceptErrors = append(acceptErrors, err)
+	})
+	a.SetNewConnectCallback(func(fd int, sa unix.Sockaddr) {
+		t.Fatal("expect no connection accepted")
+	})
+
+	conn, err := net.DialTimeout("tcp", a.listener.Addr().String(), time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 把 fd 上限降到当前最大的 fd, 下一次 accept 就会 EMFILE
+	var rlimit unix.Rlimit
+	if err = unix.Getrlimit(unix.RLIMIT_NOFILE, &rlimit); err != nil {
+		t.Fatal(err)
+	}
+	nextFd, err := unix.Open("/dev/null", unix.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = unix.Close(nextFd)
+	limited := rlimit
+	limited.Cur = uint64(nextFd)
+	if err = unix.Setrlimit(unix.RLIMIT_NOFILE, &limited); err != nil {
+		t.Skipf("setrlimit error[%v]", err)
+	}
+	a.AcceptHandle()
+	if err = unix.Setrlimit(unix.RLIMIT_NOFILE, &rlimit); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(acceptErrors) != 1 || acceptErrors[0] != unix.EMFILE {
+		t.Fatalf("expect EMFILE, get %v", acceptErrors)
+	}
+	if !a.backoffPaused {
+		t.Fatal("expect accept paused")
+	}
+	if a.reserveFd < 0 {
+		t.Fatal("expect reserve fd reopened")
+	}
+
+	// 排队的连接被预留 fd accept 出来之后直接关闭
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err = conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expect connection closed by server")
+	}
+}
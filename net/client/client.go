@@ -0,0 +1,215 @@
+package tcpclient
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/RussellLuo/timingwheel"
+	"github.com/zput/zput_net_golang/net/connect"
+	"github.com/zput/zput_net_golang/net/connector"
+	"github.com/zput/zput_net_golang/net/event_loop"
+	"github.com/zput/zput_net_golang/net/log"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/server"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	ErrNoLoop        = errors.New("client needs at least one event loop")
+	ErrNoTimingWheel = errors.New("client retry needs a timing wheel")
+)
+
+// Client 主动连接远端, 与 tcpserver.Server 共用 loop 与时间轮
+type Client struct {
+	options     *protocol.Options
+	handleEvent tcpserver.IHandleEvent
+	loops       []*event_loop.EventLoop
+	timingWheel *timingwheel.TimingWheel
+
+	mutex         sync.Mutex
+	nextLoopIndex int
+	connector     *connector.Connector
+	connect       *connect.Connect
+
+	// 用户调用 Disconnect/Stop 之后不再重连
+	wantConnect protocol.Bool
+
+	connectErrorCallback connector.OnConnectErrorCallback
+}
+
+// New 创建 Client; loops 一般来自 Server.SubLoops(), 每次 Connect 轮询挑选一个
+func New(handleEvent tcpserver.IHandleEvent, loops []*event_loop.EventLoop, tw *timingwheel.TimingWheel, opts ...protocol.Option) (*Client, error) {
+	if len(loops) == 0 {
+		return nil, ErrNoLoop
+	}
+	options := protocol.NewOptions(opts...)
+	if options.GetRetry() != nil && tw == nil {
+		return nil, ErrNoTimingWheel
+	}
+	return &Client{
+		options:     options,
+		handleEvent: handleEvent,
+		loops:       loops,
+		timingWheel: tw,
+	}, nil
+}
+
+// Connect 发起连接, 连接建立后回调 ConnectCallback
+func (this *Client) Connect() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	loop := this.getOneLoop()
+	c, err := connector.New(this.options.GetNet(), loop)
+	if err != nil {
+		this.options.GetLogger().Error("new connector failed", log.F("address", this.options.GetNet().Address), log.Err(err))
+		return err
+	}
+	c.SetLogger(this.options.GetLogger())
+	c.SetNewConnectCallback(this.newConnectedFunc(loop))
+	c.SetErrorCallback(this.connectError)
+	if retry := this.options.GetRetry(); retry != nil {
+		c.SetRetry(retry, this.timingWheel)
+	}
+	if this.connector != nil {
+		this.connector.Stop()
+	}
+	this.connector = c
+
+	this.wantConnect.Set(true)
+	c.Start()
+	return nil
+}
+
+// Disconnect 关闭写端, 等待对端关闭连接; 之后不会再重连, 已经安排的重试也取消
+func (this *Client) Disconnect() {
+	this.wantConnect.Set(false)
+
+	this.mutex.Lock()
+	if this.connector != nil {
+		this.connector.Stop()
+	}
+	c := this.connect
+	this.mutex.Unlock()
+
+	if c != nil {
+		_ = c.ShutdownWrite()
+	}
+}
+
+// Stop 停止正在进行的连接以及等待中的重连
+func (this *Client) Stop() {
+	this.wantConnect.Set(false)
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.connector != nil {
+		this.connector.Stop()
+	}
+}
+
+// Connection 当前的连接, 没有连接时返回 nil
+func (this *Client) Connection() *connect.Connect {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.connect
+}
+
+// Attempts 最近一轮连接(包括重连)已经尝试的次数
+func (this *Client) Attempts() int64 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.connector == nil {
+		return 0
+	}
+	return this.connector.Attempts()
+}
+
+// LastError 最近一次连接失败的原因
+func (this *Client) LastError() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.connector == nil {
+		return nil
+	}
+	return this.connector.LastError()
+}
+
+func (this *Client) SetConnectErrorCallback(connectErrorCallback connector.OnConnectErrorCallback) {
+	this.connectErrorCallback = connectErrorCallback
+}
+
+func (this *Client) getOneLoop() *event_loop.EventLoop {
+	loop := this.loops[this.nextLoopIndex]
+	this.nextLoopIndex = (this.nextLoopIndex + 1) % len(this.loops)
+	return loop
+}
+
+// newConnectedFunc connector 在 loop 中回调, 这里直接在同一个 loop 中创建 Connect
+func (this *Client) newConnectedFunc(loop *event_loop.EventLoop) protocol.OnNewConnectCallback {
+	return func(fd int, sa unix.Sockaddr) {
+		c, err := connect.New(loop, fd, sa, this.timingWheel, this.options.IdleTime, this.options.GetCode())
+		if err != nil {
+			this.options.GetLogger().Error("new connection failed", log.Fd(fd), log.Err(err))
+			return
+		}
+		c.SetLogger(this.options.GetLogger())
+		c.Logger().Debug("connection connected")
+
+		c.SetMessageCallback(this.handleEvent.MessageCallback)
+		c.SetConnectCloseCallback(this.connectCloseEvent)
+		c.SetWriteCompleteCallback(this.handleEvent.WriteCompletCallback)
+		if high := this.options.GetHighWatermark(); high > 0 {
+			c.SetHighWatermarkCallback(this.handleEvent.OnHighWatermark, high)
+			c.SetLowWatermarkCallback(this.handleEvent.OnLowWatermark, this.options.GetLowWatermark())
+		}
+		c.SetOutputLimit(this.options.GetMaxOutputBuffer())
+		c.SetDecodeErrorCallback(this.options.GetDecodeErrorCallback())
+		c.SetMaxBufferedBytes(this.options.GetMaxBufferedBytes())
+		if config := this.options.GetTLSConfig(); config != nil {
+			c.EnableTLS(config, true, this.options.GetTLSHandshakeTimeout())
+			c.SetHandshakeCallback(this.handleEvent.ConnectCallback)
+		}
+
+		this.mutex.Lock()
+		this.connect = c
+		this.mutex.Unlock()
+
+		if err := c.ConnectedHandle(); err != nil {
+			// fd 已经关闭, 不会回调 connectCloseEvent
+			this.mutex.Lock()
+			if this.connect == c {
+				this.connect = nil
+			}
+			this.mutex.Unlock()
+			return
+		}
+		if !c.IsTLS() {
+			this.handleEvent.ConnectCallback(c)
+		}
+	}
+}
+
+func (this *Client) connectError(err error) {
+	if this.connectErrorCallback != nil {
+		this.connectErrorCallback(err)
+	}
+}
+
+func (this *Client) connectCloseEvent(c *connect.Connect) {
+	this.handleEvent.ConnectCloseCallback(c)
+
+	this.mutex.Lock()
+	if this.connect == c {
+		this.connect = nil
+	}
+	reconnector := this.connector
+	this.mutex.Unlock()
+
+	// 对端关闭或者出错, 按配置重连
+	if this.options.GetRetry() != nil && this.wantConnect.Get() && reconnector != nil {
+		c.Logger().Info("connection closed, reconnecting")
+		reconnector.Restart()
+	}
+}
@@ -0,0 +1,189 @@
+package net
+
+import (
+	"github.com/zput/zput_net_golang/net/client"
+	"github.com/zput/zput_net_golang/net/connect"
+	"github.com/zput/zput_net_golang/net/log"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/server"
+	"testing"
+	"time"
+)
+
+type exampleClient struct {
+	tcpserver.HandleEventImpl
+	send    string
+	receive chan string
+}
+
+func (this *exampleClient) ConnectCallback(c *connect.Connect) {
+	log.Infof("client connect:[%s]", c.PeerAddr())
+	if err := c.WriteInSelfLoop([]byte(this.send)); err != nil {
+		panic(err)
+	}
+}
+
+func (this *exampleClient) MessageCallback(c *connect.Connect, buf []byte) []byte {
+	this.receive <- string(buf)
+	return nil
+}
+
+func TestClientEcho(t *testing.T) {
+	log.SetLevel(log.LevelDebug)
+
+	s, err := tcpserver.New(new(exampleRW),
+		protocol.Network("tcp"),
+		protocol.Address(":51834"),
+		protocol.NumLoops(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+
+	handler := &exampleClient{send: "hello client", receive: make(chan string, 1)}
+	c, err := tcpclient.New(handler, s.SubLoops(), s.TimingWheel(),
+		protocol.Address("127.0.0.1:51834"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-handler.receive:
+		if got != handler.send {
+			t.Fatalf("expect %s, but get %s", handler.send, got)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait echo timeout")
+	}
+	if c.Connection() == nil {
+		t.Fatal("expect connection, get nil")
+	}
+
+	c.Disconnect()
+	s.Stop()
+}
+
+func TestClientConnectRefused(t *testing.T) {
+	s, err := tcpserver.New(new(exampleRW),
+		protocol.Address(":51835"),
+		protocol.NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+
+	c, err := tcpclient.New(new(tcpserver.HandleEventImpl), s.SubLoops(), s.TimingWheel(),
+		protocol.Address("127.0.0.1:51836"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	c.SetConnectErrorCallback(func(err error) {
+		errs <- err
+	})
+	if err = c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errs:
+		log.Infof("connect error[%v]", err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("expect connect error")
+	}
+	s.Stop()
+}
+
+func TestClientReconnect(t *testing.T) {
+	loopServer, err := tcpserver.New(new(exampleRW),
+		protocol.Address(":51837"),
+		protocol.NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go loopServer.Start()
+
+	handler := &exampleClient{send: "hello again", receive: make(chan string, 1)}
+	c, err := tcpclient.New(handler, loopServer.SubLoops(), loopServer.TimingWheel(),
+		protocol.Address("127.0.0.1:51838"),
+		protocol.Retry(protocol.Backoff{
+			Initial:    50 * time.Millisecond,
+			Max:        200 * time.Millisecond,
+			Multiplier: 2,
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 对端还没有启动, 等待几次重试
+	time.Sleep(time.Millisecond * 500)
+	if c.Attempts() < 2 {
+		t.Fatalf("expect at least 2 attempts, get %d", c.Attempts())
+	}
+	if c.LastError() == nil {
+		t.Fatal("expect last error, get nil")
+	}
+
+	s, err := tcpserver.New(new(exampleRW),
+		protocol.Address(":51838"),
+		protocol.NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+
+	select {
+	case got := <-handler.receive:
+		if got != handler.send {
+			t.Fatalf("expect %s, but get %s", handler.send, got)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait reconnect timeout")
+	}
+
+	c.Stop()
+	c.Disconnect()
+	s.Stop()
+	loopServer.Stop()
+}
+
+func TestClientDisconnectCancelsRetry(t *testing.T) {
+	loopServer, err := tcpserver.New(new(exampleRW),
+		protocol.Address(":51854"),
+		protocol.NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go loopServer.Start()
+	defer loopServer.Stop()
+
+	c, err := tcpclient.New(new(tcpserver.HandleEventImpl), loopServer.SubLoops(), loopServer.TimingWheel(),
+		protocol.Address("127.0.0.1:51855"),
+		protocol.Retry(protocol.Backoff{
+			Initial:    50 * time.Millisecond,
+			Max:        50 * time.Millisecond,
+			Multiplier: 2,
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 120)
+
+	// Disconnect 之后已经安排的重试不再发起连接
+	c.Disconnect()
+	time.Sleep(time.Millisecond * 50)
+	attempts := c.Attempts()
+	time.Sleep(time.Millisecond * 300)
+	if c.Attempts() != attempts {
+		t.Fatalf("expect no retry after disconnect, attempts %d -> %d", attempts, c.Attempts())
+	}
+}
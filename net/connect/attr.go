@@ -0,0 +1,61 @@
+package connect
+
+// AttrKey 连接属性的 key; 按指针区分, 不同模块用同名 key 也不会冲突
+type AttrKey struct {
+	name string
+}
+
+// NewAttrKey 一般定义为包级变量: var userKey = connect.NewAttrKey("user")
+func NewAttrKey(name string) *AttrKey {
+	return &AttrKey{name: name}
+}
+
+func (this *AttrKey) String() string {
+	return this.name
+}
+
+// SetContext 保存上层的会话状态, 连接关闭后自动清空
+func (this *Connect) SetContext(ctx interface{}) {
+	this.attrMutex.Lock()
+	defer this.attrMutex.Unlock()
+	this.context = ctx
+}
+
+// Context 获取 SetContext 保存的会话状态
+func (this *Connect) Context() interface{} {
+	this.attrMutex.Lock()
+	defer this.attrMutex.Unlock()
+	return this.context
+}
+
+// SetAttr 设置连接属性, 连接关闭后自动清空
+func (this *Connect) SetAttr(key *AttrKey, value interface{}) {
+	this.attrMutex.Lock()
+	defer this.attrMutex.Unlock()
+	if this.attrs == nil {
+		this.attrs = make(map[*AttrKey]interface{})
+	}
+	this.attrs[key] = value
+}
+
+// Attr 获取连接属性
+func (this *Connect) Attr(key *AttrKey) (interface{}, bool) {
+	this.attrMutex.Lock()
+	defer this.attrMutex.Unlock()
+	value, ok := this.attrs[key]
+	return value, ok
+}
+
+// DeleteAttr 删除连接属性
+func (this *Connect) DeleteAttr(key *AttrKey) {
+	this.attrMutex.Lock()
+	defer this.attrMutex.Unlock()
+	delete(this.attrs, key)
+}
+
+func (this *Connect) clearAttrs() {
+	this.attrMutex.Lock()
+	defer this.attrMutex.Unlock()
+	this.context = nil
+	this.attrs = nil
+}
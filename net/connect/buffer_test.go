@@ -0,0 +1,139 @@
+package connect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/zput/zput_net_golang/net/protocol"
+	"golang.org/x/sys/unix"
+)
+
+// wrapInBuffer 让 inBuffer 中的数据跨越环形缓冲区的边界, 两段分别是 first 和 end
+func wrapInBuffer(c *Connect, first, end string) {
+	capacity := c.inBuffer.Capacity()
+	filler := make([]byte, capacity-len(end))
+	copy(filler[len(filler)-len(end):], first[:len(end)])
+	_, _ = c.inBuffer.Write(filler)
+	c.inBuffer.Retrieve(capacity - len(first))
+	_, _ = c.inBuffer.Write([]byte(first[len(end):] + end))
+}
+
+func TestConnectBufferView(t *testing.T) {
+	c, peer := newTestConnect(t)
+	defer unix.Close(peer)
+
+	wrapInBuffer(c, "abcd", "ef")
+	c.temporaryBuf = []byte("ghij")
+	if head, tail := c.inBuffer.PeekAll(); string(head) != "abcd" || string(tail) != "ef" {
+		t.Fatalf("expect wrapped ring buffer, get %q %q", head, tail)
+	}
+	if c.BufferLength() != 10 {
+		t.Fatalf("expect 10 bytes, get %d", c.BufferLength())
+	}
+
+	if idx := c.IndexByte(0, 'f'); idx != 5 {
+		t.Fatalf("expect f at 5, get %d", idx)
+	}
+	if idx := c.IndexByte(3, 'h'); idx != 7 {
+		t.Fatalf("expect h at 7, get %d", idx)
+	}
+	if idx := c.IndexByte(6, 'a'); idx != -1 {
+		t.Fatalf("expect a not found after 6, get %d", idx)
+	}
+
+	p := make([]byte, 6)
+	if n := c.PeekAt(p, 2); n != 6 || string(p) != "cdefgh" {
+		t.Fatalf("expect cdefgh, get %q", p[:n])
+	}
+	if n := c.PeekAt(p, 7); n != 3 {
+		t.Fatalf("expect 3 bytes at the end, get %d", n)
+	}
+
+	// 同一段中的数据不拷贝
+	if frame := c.Frame(6, 4); string(frame) != "ghij" || &frame[0] != &c.temporaryBuf[0] {
+		t.Fatalf("expect zero copy view of ghij, get %q", frame)
+	}
+	if frame := c.Frame(3, 4); string(frame) != "defg" {
+		t.Fatalf("expect defg across segments, get %q", frame)
+	}
+	if frame := c.Frame(8, 3); frame != nil {
+		t.Fatalf("expect nil beyond buffer, get %q", frame)
+	}
+	if allocs := testing.AllocsPerRun(100, func() {
+		c.Frame(1, 8)
+		c.IndexByte(0, 'j')
+	}); allocs != 0 {
+		t.Fatalf("expect no allocation, get %v", allocs)
+	}
+
+	c.ShiftN(5)
+	if frame := c.Read(); string(frame) != "fghij" {
+		t.Fatalf("expect fghij after shift, get %q", frame)
+	}
+}
+
+func benchmarkHandleData(b *testing.B, codec protocol.ICodec, frame []byte, frames int) {
+	c, peer := newTestConnect(b)
+	defer unix.Close(peer)
+	c.codeImp = codec
+	c.SetMessageCallback(func(c *Connect, data []byte) []byte {
+		return nil
+	})
+
+	var data []byte
+	for i := 0; i < frames; i++ {
+		out, err := codec.Encode(c, frame)
+		if err != nil {
+			b.Fatal(err)
+		}
+		data = append(data, out...)
+	}
+	half := len(data)/2 + 1
+
+	b.Run("contiguous", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			c.handleData(data)
+		}
+	})
+	// 前一半已经在 inBuffer 中, 帧跨越 inBuffer 和这次读到的数据
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			_, _ = c.inBuffer.Write(data[:half])
+			c.handleData(data[half:])
+		}
+	})
+}
+
+func BenchmarkLineBasedFrameCodec(b *testing.B) {
+	benchmarkHandleData(b, new(protocol.LineBasedFrameCodec), bytes.Repeat([]byte("a"), 31), 128)
+}
+
+func BenchmarkDelimiterBasedFrameCodec(b *testing.B) {
+	benchmarkHandleData(b, protocol.NewDelimiterBasedFrameCodec('|'), bytes.Repeat([]byte("a"), 31), 128)
+}
+
+// BenchmarkBuiltInFrameCodec 缓冲区中的数据整个作为一帧
+func BenchmarkBuiltInFrameCodec(b *testing.B) {
+	benchmarkHandleData(b, new(protocol.BuiltInFrameCodec), bytes.Repeat([]byte("a"), 32), 128)
+}
+
+func BenchmarkFixedLengthFrameCodec(b *testing.B) {
+	benchmarkHandleData(b, protocol.NewFixedLengthFrameCodec(32), bytes.Repeat([]byte("a"), 32), 128)
+}
+
+func BenchmarkLengthFieldBasedFrameCodec(b *testing.B) {
+	codec := protocol.NewLengthFieldBasedFrameCodec(protocol.EncoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 4,
+	}, protocol.DecoderConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldLength:   4,
+		InitialBytesToStrip: 4,
+	})
+	benchmarkHandleData(b, codec, bytes.Repeat([]byte("a"), 28), 128)
+}
@@ -1,18 +1,22 @@
 package connect
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/RussellLuo/timingwheel"
-	"github.com/panjf2000/gnet/pool/bytebuffer"
 	"github.com/zput/ringbuffer"
 	"github.com/zput/ringbuffer/pool"
 	"github.com/zput/zput_net_golang/net/event_loop"
 	"github.com/zput/zput_net_golang/net/log"
+	"github.com/zput/zput_net_golang/net/metrics"
 	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/worker"
 	"golang.org/x/sys/unix"
 	"net"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,6 +34,7 @@ const(
 
 // Connection TCP 连接
 type Connect struct {
+	id                         uint64
 	loop                       *event_loop.EventLoop
 	event                      *event_loop.Event
 	// TODO init
@@ -37,28 +42,98 @@ type Connect struct {
 	temporaryBuf []byte // don't need init
 	outBuffer *ringbuffer.RingBuffer // write buffer
 	inBuffer  *ringbuffer.RingBuffer // read buffer
-	// TODO initial
-	byteBuffer     *bytebuffer.ByteBuffer // bytes buffer for buffering current packet and data in ring-buffer
+	// 跨越环形缓冲区边界的帧拷贝到这里, 在连接上复用
+	frameBuf []byte
 
 	messageCallback OnMessageCallback
 	connectCloseCallback OnConnectCloseCallback
 	writeCompleteCallback OnWriteCompletCallback
-	state ConnectState
+	// ConnectState, Send/Close 等在 loop 之外也会读取, 用原子操作
+	state int32
+	// 发送缓冲区写完后关闭连接
+	closeAfterFlush bool
+	// SendAndClose 之后不再接受新的发送, 收到的数据也不再处理
+	closing protocol.Bool
 
 	fd        int
 	peerAddr  string
+	// loop 为边沿触发时读写都要进行到 EAGAIN
+	edgeTriggered bool
 
 	idleTime    time.Duration
 	activeTime  protocol.Int64
 	timingWheel *timingwheel.TimingWheel
 	codeImp protocol.ICodec
+
+	// 上层的会话状态, 连接关闭后清空
+	attrMutex sync.Mutex
+	context   interface{}
+	attrs     map[*AttrKey]interface{}
+
+	// 开启 TLS 后位于 socket 和 codec 之间
+	tlsSession        *tlsSession
+	handshakeCallback OnHandshakeCallback
+
+	// 发送缓冲区水位
+	highWatermark         int
+	lowWatermark          int
+	aboveHighWatermark    protocol.Bool
+	highWatermarkCallback OnWatermarkCallback
+	lowWatermarkCallback  OnWatermarkCallback
+	maxOutputBuffer       int
+	overflowPolicy        protocol.OverflowPolicy
+
+	// 所属 Server 共享的指标, 没有开启时为 nil
+	metrics *metrics.ConnectMetrics
+	// 带有连接字段的日志
+	logger *log.FieldLogger
+
+	// 解码失败的处理和接收缓冲区上限
+	decodeErrorCallback protocol.OnDecodeErrorCallback
+	maxBufferedBytes    int
+	codecContext        interface{}
+
+	// 排在 outBuffer 后面的文件和数据, queuedBytes 是其中数据的字节数
+	sendQueue   []*sendItem
+	queuedBytes int
+
+	// 开启 worker pool 时 MessageCallback 在 worker 中按顺序执行, 否则为 nil
+	mailbox            *worker.Mailbox
+	workerRejectPolicy protocol.WorkerRejectPolicy
+
+	// SendWithCallback 等待发送缓冲区写完的回调
+	sendCallbacks []OnSendCallback
 }
 
 var ErrConnectionClosed = errors.New("connection closed")
 
-// New 创建 Connection
+func (this *Connect) getState() ConnectState {
+	return ConnectState(atomic.LoadInt32(&this.state))
+}
+
+func (this *Connect) setState(state ConnectState) {
+	atomic.StoreInt32(&this.state, int32(state))
+}
+
+func (this *Connect) casState(old, new ConnectState) bool {
+	return atomic.CompareAndSwapInt32(&this.state, int32(old), int32(new))
+}
+
+// sendable 连接已经建立并且没有调用过 SendAndClose, Send 系列在任意协程调用前检查
+func (this *Connect) sendable() bool {
+	return this.getState() == Connected && !this.closing.Get()
+}
+
+// connectID 进程内单调递增的连接 id, 第一个连接为 1
+var connectID uint64
+
+// defaultLogger 没有调用 SetLogger 时所有连接共享, 限流也是共享的
+var defaultLogger = log.Default()
+
+// New 创建 Connection, fd 需要已经是非阻塞的(accept4 SOCK_NONBLOCK 或者 connector 设置)
 func New(loop *event_loop.EventLoop, fd int, sa unix.Sockaddr, tw *timingwheel.TimingWheel, idleTime time.Duration, codeImp protocol.ICodec) (*Connect, error) {
 	var tcpConnection = Connect{
+		id:atomic.AddUint64(&connectID, 1),
 		loop:loop,
 		fd:fd,
 		peerAddr:sockAddrToString(sa),
@@ -66,29 +141,21 @@ func New(loop *event_loop.EventLoop, fd int, sa unix.Sockaddr, tw *timingwheel.T
 		outBuffer:pool.Get(),
 		inBuffer:pool.Get(),
 		codeImp: codeImp,
-		state:Disconnected,
+		state:int32(Disconnected),
 		idleTime:idleTime,
 		timingWheel:tw,
+		edgeTriggered:loop.IsEdgeTriggered(),
 	}
 
 	tcpConnection.outBuffer.RetrieveAll()
 	tcpConnection.inBuffer.RetrieveAll()
-
-	var(
-		err error
-	)
+	tcpConnection.SetLogger(defaultLogger)
 
 	if tcpConnection.idleTime > 0 {
 		_ = tcpConnection.activeTime.Swap(time.Now().Unix())
 		tcpConnection.timingWheel.AfterFunc(tcpConnection.idleTime, tcpConnection.closeTimeoutConn())
 	}
 
-	//设置不阻塞
-	err = tcpConnection.setNoDelay(true)
-	if err != nil{
-		return nil, err
-	}
-
 	//设置Tcp Accept event_loop.
 	tcpConnection.event = event_loop.NewEvent(loop, fd)
 	////将这个accept event添加到loop，给多路复用监听。
@@ -108,7 +175,7 @@ func New(loop *event_loop.EventLoop, fd int, sa unix.Sockaddr, tw *timingwheel.T
 
 //Close关闭连接
 func (this *Connect) Close() error {
-	if this.state == Disconnected {
+	if this.getState() == Disconnected {
 		return ErrConnectionClosed
 	}
 
@@ -118,6 +185,28 @@ func (this *Connect) Close() error {
 	return nil
 }
 
+// CloseGracefully 等待发送缓冲区中的数据写完再关闭连接; 开启 worker pool 时还要等交给 worker 的帧处理完
+func (this *Connect) CloseGracefully() error {
+	if this.getState() == Disconnected {
+		return ErrConnectionClosed
+	}
+
+	this.loop.RunInLoop(this.closeGracefullyInLoop)
+	return nil
+}
+
+func (this *Connect) closeGracefullyInLoop() {
+	if this.getState() == Disconnected {
+		return
+	}
+	// 交给 worker 的帧处理完、回复写完之后再关闭; TLS 握手完成前暂存的明文也要等写出
+	if !this.writePending() && this.workerIdle() {
+		this.closeEvent()
+		return
+	}
+	this.closeAfterFlush = true
+}
+
 func (this *Connect) closeTimeoutConn() func() {
 	return func() {
 		now := time.Now()
@@ -130,13 +219,14 @@ func (this *Connect) closeTimeoutConn() func() {
 	}
 }
 
-func (this *Connect) setNoDelay(enable bool)(err error){
-	if err = unix.SetNonblock(this.fd, enable); err != nil {
-		_ = unix.Close(this.fd)
-		log.Error("set nonblock:", err)
-		return
-	}
-	return nil
+// SetLogger 使用 Server 的 logger, 附加上连接的字段
+func (this *Connect) SetLogger(logger *log.FieldLogger) {
+	this.logger = logger.With(log.ConnID(this.id), log.Fd(this.fd), log.Peer(this.peerAddr), log.Loop(this.loop.SequenceID))
+}
+
+// Logger 带有连接字段的 logger, 上层可以在回调中使用
+func (this *Connect) Logger() *log.FieldLogger {
+	return this.logger
 }
 
 func (this *Connect) SetMessageCallback(messageCallback OnMessageCallback) {
@@ -156,11 +246,12 @@ func (this *Connect) ConnectedHandle()(err error){
 	//将这个accept event添加到loop，给多路复用监听。
 	err = this.event.Register()
 	if err != nil{
-		log.Error("creating tcpConnect failure; AddEvent; error[%v]", err)
+		this.logger.Error("register connection event failed", log.Err(err))
+		this.abortConnect()
 		return err
 	}
 
-	this.state = Connected
+	this.setState(Connected)
 	err = this.event.EnableReading(true)
 	//epoll为电平触发
 	/*
@@ -182,54 +273,112 @@ func (this *Connect) ConnectedHandle()(err error){
 
 	//event->enableWriting(true);
 	err = this.event.EnableErrorEvent(true)
+	if err != nil {
+		this.logger.Error("enable connection event failed", log.Err(err))
+		this.abortConnect()
+		return err
+	}
+	if this.tlsSession != nil {
+		this.startTLS()
+	}
 	return
 }
 
+// abortConnect ConnectedHandle 失败时关闭 fd 并归还缓冲区; 连接还没有交给上层, 不回调 ConnectCloseCallback
+func (this *Connect) abortConnect() {
+	this.connectCloseCallback = nil
+	// Register 失败时还是 Disconnected, closeEvent 只处理没有关闭的连接
+	this.setState(Connecting)
+	this.closeEvent()
+}
+
 func (this *Connect) readEvent() {
 	this.updateActivityTime()
 
-	if !this.outBuffer.IsEmpty() {
-		// close read event
-		err := this.event.EnableReading(false)
-		if err != nil{
-			log.Errorf("enable reading; error[%v]", err)
+	for {
+		if this.sendPending() {
+			// close read event; 发送缓冲区写完之后重新打开, 边沿触发时 MOD 也会重新触发
+			err := this.event.EnableReading(false)
+			if err != nil{
+				this.logger.Error("disable reading failed", log.Err(err))
+			}
+			return
 		}
-		return
-	}
 
-	n, err := unix.Read(this.fd, this.buf)
-	if n == 0 || err != nil {
-		if err != unix.EAGAIN {
-			// TODO zxc
-			log.Errorf("fd[%d] readEvent error[%v]", this.fd, err)
-			this.closeEvent()
-		}
-		return
-	}
-	if n > 0{
-		this.temporaryBuf = this.buf[:n] // will change by shiftN; ReadN; resetBuffer
-		for inFrame, _ := this.read(); inFrame != nil; inFrame, _ = this.read() {
-			out := this.messageCallback(this, inFrame)
-			if out != nil {
-				outFrame, _ := this.codeImp.Encode(this, out)
-				if len(outFrame)>0{
-					this.write(outFrame)
+		n, err := unix.Read(this.fd, this.buf)
+		if n == 0 || err != nil {
+			if err != unix.EAGAIN {
+				if err != nil {
+					this.logger.Error("read failed", log.Err(err))
+				} else {
+					this.logger.Debug("peer closed")
 				}
+				this.closeEvent()
+			}
+			return
+		}
+		this.metrics.Read(n)
+		if this.tlsSession != nil {
+			// 解密出来的明文直接在这里解码; 握手期间 in 满了时暂停读
+			if !this.tlsRead(this.buf[:n]) {
+				return
 			}
+		} else {
+			this.handleData(this.buf[:n])
 		}
 
-		this.inBuffer.Write(this.temporaryBuf)
+		// 水平触发每次只读一次, 边沿触发一直读到 EAGAIN
+		if !this.edgeTriggered || this.getState() == Disconnected {
+			return
+		}
 	}
 }
 
-func (this *Connect) read()([]byte, error){
-	result, err := this.codeImp.Decode(this)
-	if err != nil{
-		log.Errorf("decodeAllDataHaveAccepted; error[%v]", err)
-		return nil, err
+// handleData 解码收到的数据, 不完整的部分留在 inBuffer
+func (this *Connect) handleData(data []byte) {
+	this.temporaryBuf = data // will change by shiftN; ReadN; resetBuffer
+	for {
+		// SendAndClose 之后(包括在回调中调用)丢弃剩下的数据, 只等待最后的消息写完
+		if this.closing.Get() {
+			this.temporaryBuf = nil
+			return
+		}
+		before := this.BufferLength()
+		inFrame, err := this.codeImp.Decode(this)
+		if err != nil {
+			// 数据不够一帧是正常情况, 等待下一次读
+			if protocol.IsIncompleteFrame(err) || !this.handleDecodeError(err, before) {
+				break
+			}
+			continue
+		}
+		if inFrame == nil {
+			break
+		}
+
+		this.metrics.Decoded()
+		if this.mailbox != nil {
+			if !this.dispatch(inFrame) {
+				return
+			}
+			continue
+		}
+		out := this.messageCallback(this, inFrame)
+		if out != nil && !this.closing.Get() {
+			this.encodeAndWrite(out)
+		}
+		// 回调或者写出错时连接可能已经关闭, 缓冲区已经归还
+		if this.getState() == Disconnected {
+			return
+		}
+	}
+	if this.getState() == Disconnected {
+		return
 	}
 
-	return result, nil
+	this.inBuffer.Write(this.temporaryBuf)
+	this.temporaryBuf = nil
+	this.checkMaxBuffered()
 }
 
 
@@ -266,30 +415,42 @@ func (this *Connect) read()([]byte, error){
 func (this *Connect) writeEvent() {
 	this.updateActivityTime()
 
-	first, end := this.outBuffer.PeekAll()
-	n, err := unix.Write(this.fd, first)
-	if err != nil {
-		if err == unix.EAGAIN {
-			return
-		}
-		this.closeEvent()
-		return
-	}
-	this.outBuffer.Retrieve(n)
-
-	if n == len(first) && len(end) > 0 {
-		n, err = unix.Write(this.fd, end)
-		if err != nil {
-			if err == unix.EAGAIN {
+	for {
+		if !this.outBuffer.IsEmpty() {
+			// 环形缓冲区的两段用一次 writev 写出
+			first, end := this.outBuffer.PeekAll()
+			n, err := writev(this.fd, [][]byte{first, end})
+			if err != nil {
+				if err == unix.EAGAIN {
+					return
+				}
+				this.closeEvent()
 				return
 			}
-			this.closeEvent()
-			return
+			this.outBuffer.Retrieve(n)
+			this.metrics.Written(n)
+			this.metrics.Buffered(-n)
+			this.checkLowWatermark()
+
+			if !this.outBuffer.IsEmpty() {
+				// 边沿触发时没写完又没遇到 EAGAIN 不会再有通知, 继续写
+				if this.edgeTriggered && this.getState() != Disconnected {
+					continue
+				}
+				break
+			}
 		}
-		this.outBuffer.Retrieve(n)
+
+		// outBuffer 写完之后发送排在后面的文件和数据
+		if len(this.sendQueue) == 0 || !this.flushSendQueue() {
+			break
+		}
+	}
+	if this.getState() == Disconnected {
+		return
 	}
 
-	if this.outBuffer.Size() == 0 {
+	if !this.sendPending() {
 		if this.event.IsWriting() == true{
 			_ = this.event.EnableWriting(false)
 		}
@@ -297,32 +458,119 @@ func (this *Connect) writeEvent() {
 			_ = this.event.EnableReading(true)
 		}
 
+		this.completeSends()
 		//回调写完成函数
 		if this.writeCompleteCallback != nil{
 			this.writeCompleteCallback(this)
 		}
+
+		this.closeIfDrained()
 	}
 }
 
-func (this *Connect) write(data []byte) {
-	if !this.outBuffer.IsEmpty(){
-		_, _ = this.outBuffer.Write(data)
-	} else {
-		n, err := unix.Write(this.fd, data)
+// encodeAndWrite codec 支持 FramesEncoder 时帧头和数据分开写出, 不拼接;
+// 返回编码失败的错误, 超过发送缓冲区上限被丢弃时返回 ErrOutputOverflow
+func (this *Connect) encodeAndWrite(out []byte) error {
+	if encoder, ok := this.codeImp.(protocol.FramesEncoder); ok {
+		frames, err := encoder.EncodeFrames(this, out)
 		if err != nil {
-			if err == unix.EAGAIN {
-				_, _ = this.outBuffer.Write(data)
-				_ = this.event.EnableWriting(true)
-				return
+			return err
+		}
+		if len(frames) > 0 {
+			this.metrics.Encoded()
+			if !this.writeFrames(frames) {
+				return ErrOutputOverflow
 			}
+		}
+		return nil
+	}
+	outFrame, err := this.codeImp.Encode(this, out)
+	if err != nil {
+		return err
+	}
+	if len(outFrame)>0{
+		this.metrics.Encoded()
+		if !this.write(outFrame) {
+			return ErrOutputOverflow
+		}
+	}
+	return nil
+}
+
+// write 返回 false 表示超过发送缓冲区上限, 数据没有写入
+func (this *Connect) write(data []byte) bool {
+	if this.tlsSession != nil {
+		this.tlsWrite(data)
+		return this.getState() != Disconnected
+	}
+	return this.writeRaw(data)
+}
+
+// writeFrames 多个缓冲区按顺序写出, 不需要先拼接
+func (this *Connect) writeFrames(frames [][]byte) bool {
+	if this.tlsSession != nil {
+		for _, frame := range frames {
+			this.tlsWrite(frame)
+			if this.getState() == Disconnected {
+				return false
+			}
+		}
+		return true
+	}
+	total := 0
+	for _, frame := range frames {
+		total += len(frame)
+	}
+	return this.writeRawFrames(frames, total)
+}
+
+// writeRaw 直接写 socket, 写不完的放进发送缓冲区
+func (this *Connect) writeRaw(data []byte) bool {
+	return this.writeRawFrames([][]byte{data}, len(data))
+}
+
+// writeRawFrames 发送缓冲区为空时直接 writev, 写不完的部分按顺序放进发送缓冲区;
+// 只有需要放进发送缓冲区的部分受 MaxOutputBuffer 限制, 超过时返回 false
+func (this *Connect) writeRawFrames(frames [][]byte, total int) bool {
+	// 前面还有没发完的文件, 数据排在文件后面
+	if len(this.sendQueue) > 0 {
+		if this.outputOverflow(total, 0) {
+			return false
+		}
+		this.queueData(frames, total)
+		this.checkHighWatermark()
+		return true
+	}
+
+	written := 0
+	if this.outBuffer.IsEmpty() {
+		var err error
+		written, err = writev(this.fd, frames)
+		if err != nil && err != unix.EAGAIN {
 			this.closeEvent()
-			return
+			return false
 		}
-		if n < len(data) {
-			_, _ = this.outBuffer.Write(data[n:])
-			_ = this.event.EnableWriting(true)
+		this.metrics.Written(written)
+	}
+
+	if written < total {
+		if this.outputOverflow(total-written, written) {
+			return false
 		}
+		skip := written
+		for _, frame := range frames {
+			if skip >= len(frame) {
+				skip -= len(frame)
+				continue
+			}
+			_, _ = this.outBuffer.Write(frame[skip:])
+			skip = 0
+		}
+		this.metrics.Buffered(total - written)
+		_ = this.event.EnableWriting(true)
 	}
+	this.checkHighWatermark()
+	return true
 }
 
 func (this *Connect) errEvent() {
@@ -331,49 +579,87 @@ func (this *Connect) errEvent() {
 
 // TODO 为什么C++需要加share_prt
 func (this *Connect) closeEvent() {
-	if this.state != Disconnected {
-		log.Debug("ready to close connection event")
+	if this.getState() != Disconnected {
+		this.logger.Debug("closing connection")
 		//设置状态
-		this.state = Disconnected
+		this.setState(Disconnected)
 		//在event中取消掉loop注册
 		//删除fd-event-loop
 		this.event.DisableAll()
 		this.event.UnRegister()
 
-		log.Debug("ready to close connection event; callback upper")
 		// 这个是上层的责任，应该由上层来删除。这个TcpConnect与loop，fd的联系。
 		if this.connectCloseCallback != nil {
 			this.connectCloseCallback(this)
 		}
+		// 还在排队的帧不再处理
+		if this.mailbox != nil {
+			this.mailbox.Close()
+		}
+		// 关闭回调之后会话状态跟着连接一起释放
+		this.clearAttrs()
+		if this.tlsSession != nil {
+			_ = this.tlsSession.mem.Close()
+		}
 
 		//没有析构函数，自己释放。
 		//TODO close 与 shutdown区别。
 		if err := unix.Close(this.fd); err != nil {
-			log.Error("[close fd]", err)
+			this.logger.Error("close fd failed", log.Err(err))
 		}
 
+		this.failSends(ErrConnectionClosed)
+		this.metrics.Close(this.OutBufferLength())
+		this.sendQueue = nil
+		this.queuedBytes = 0
 		pool.Put(this.inBuffer)
 		pool.Put(this.outBuffer)
 	}
 }
 
 // ShutdownWrite 关闭可写端，等待读取完接收缓冲区所有数据
+// 发送缓冲区还有数据或者 worker 还在处理时, 等写完之后再关闭写端
 func (this *Connect) ShutdownWrite() error {
-	if this.state == Connected{
-		this.state = Disconnecting
-		return unix.Shutdown(this.fd, unix.SHUT_WR)
+	if this.casState(Connected, Disconnecting) {
+		this.loop.RunInLoop(func() {
+			if this.getState() == Disconnecting && !this.writePending() && this.workerIdle() {
+				this.shutdownWriteInLoop()
+			}
+		})
 	}
 	return nil
 }
 
+func (this *Connect) shutdownWriteInLoop() {
+	if err := unix.Shutdown(this.fd, unix.SHUT_WR); err != nil {
+		this.logger.Error("shutdown write failed", log.Err(err))
+	}
+}
+
+// ID 连接的唯一 id, 创建时分配, 不会被复用
+func (this *Connect) ID() uint64 {
+	return this.id
+}
+
+// SetMetrics 开启连接的指标, 需要在 ConnectedHandle 之前调用
+func (this *Connect) SetMetrics(m *metrics.ConnectMetrics) {
+	this.metrics = m
+	m.Accept()
+}
+
+// Loop 连接所属的 loop
+func (this *Connect) Loop() *event_loop.EventLoop {
+	return this.loop
+}
+
 // PeerAddr 获取客户端地址信息
 func (this *Connect) PeerAddr() string {
 	return this.peerAddr
 }
 
-// Send 用来在非 loop 协程发送
+// WriteInSelfLoop 在任意协程写出已经编码好的数据, 不经过 codec; 需要编码时用 Send
 func (this *Connect) WriteInSelfLoop(buffer []byte) error {
-	if this.state != Connected {
+	if !this.sendable() {
 		return ErrConnectionClosed
 	}
 
@@ -383,12 +669,31 @@ func (this *Connect) WriteInSelfLoop(buffer []byte) error {
 	return nil
 }
 
+// WriteFrames 把已经编码好的多个缓冲区(例如帧头和数据)用一次 writev 发出, 不需要拼接;
+// 和 WriteInSelfLoop 一样可以在任意协程调用, 写出之前不能修改 frames
+func (this *Connect) WriteFrames(frames [][]byte) error {
+	if !this.sendable() {
+		return ErrConnectionClosed
+	}
+
+	this.loop.RunInLoop(func() {
+		this.writeFrames(frames)
+	})
+	return nil
+}
+
 func sockAddrToString(sa unix.Sockaddr) string {
 	switch sa := (sa).(type) {
 	case *unix.SockaddrInet4:
 		return net.JoinHostPort(net.IP(sa.Addr[:]).String(), strconv.Itoa(sa.Port))
 	case *unix.SockaddrInet6:
 		return net.JoinHostPort(net.IP(sa.Addr[:]).String(), strconv.Itoa(sa.Port))
+	case *unix.SockaddrUnix:
+		// accept 得到的 unix 对端一般没有绑定地址
+		if sa.Name == "" {
+			return "(unnamed unix socket)"
+		}
+		return sa.Name
 	default:
 		return fmt.Sprintf("(unknown - %T)", sa)
 	}
@@ -402,55 +707,30 @@ func (this *Connect)updateActivityTime(){
 
 //////////////////////////public API ////////////////////////////////
 
+// segments 接收缓冲区中的数据依次是 inBuffer 的两段和 temporaryBuf, 都不拷贝
+func (c *Connect) segments() [3][]byte {
+	first, end := c.inBuffer.PeekAll()
+	return [3][]byte{first, end, c.temporaryBuf}
+}
+
+// Read 返回接收缓冲区中的全部数据, 只有一段时不拷贝; 有新的 codec 应该使用 IndexByte/PeekAt/Frame
 func (c *Connect) Read() []byte {
 	if c.inBuffer.IsEmpty() {
 		return c.temporaryBuf
 	}
-
-	c.byteBuffer = bytebuffer.Get()
-
-	first, end := c.inBuffer.PeekAll()
-	if len(first) == 0{
-		_, _ = c.byteBuffer.Write(end)
-	}else{
-		_, _ = c.byteBuffer.Write(first)
-		_, _ = c.byteBuffer.Write(end)
-	}
-	_, _ = c.byteBuffer.Write(c.temporaryBuf)
-	return c.byteBuffer.Bytes()
+	return c.Frame(0, c.BufferLength())
 }
 
 func (c *Connect) ResetBuffer() {
 	c.temporaryBuf = c.temporaryBuf[:0]
 	c.inBuffer.Reset()
-	bytebuffer.Put(c.byteBuffer)
-	c.byteBuffer = nil
 }
 
 func (c *Connect) ReadN(n int) (size int, buf []byte) {
-	inBufferLen := c.inBuffer.Size()
-	tempBufferLen := len(c.temporaryBuf)
-	if totalLen := inBufferLen + tempBufferLen; totalLen < n || n <= 0 {
+	if totalLen := c.BufferLength(); totalLen < n || n <= 0 {
 		n = totalLen
 	}
-	size = n
-	if c.inBuffer.IsEmpty() {
-		buf = c.temporaryBuf[:n]
-		return
-	}
-	head, tail := c.inBuffer.Peek(n)
-	c.byteBuffer = bytebuffer.Get()
-	_, _ = c.byteBuffer.Write(head)
-	_, _ = c.byteBuffer.Write(tail)
-	if inBufferLen >= n {
-		buf = c.byteBuffer.Bytes()
-		return
-	}
-
-	restSize := n - inBufferLen
-	_, _ = c.byteBuffer.Write(c.temporaryBuf[:restSize])
-	buf = c.byteBuffer.Bytes()
-	return
+	return n, c.Frame(0, n)
 }
 
 func (c *Connect) ShiftN(n int) (size int) {
@@ -467,9 +747,6 @@ func (c *Connect) ShiftN(n int) (size int) {
 		return
 	}
 
-	bytebuffer.Put(c.byteBuffer)
-	c.byteBuffer = nil
-
 	if inBufferLen >= n {
 		c.inBuffer.Retrieve(n)
 		return
@@ -484,3 +761,61 @@ func (c *Connect) ShiftN(n int) (size int) {
 func (c *Connect) BufferLength() int {
 	return c.inBuffer.Size() + len(c.temporaryBuf)
 }
+
+// PeekAt 从 offset 开始拷贝 len(p) 个字节到 p, 不移动读位置; 返回拷贝的字节数, 数据不够时小于 len(p)
+func (c *Connect) PeekAt(p []byte, offset int) int {
+	copied := 0
+	for _, seg := range c.segments() {
+		if offset >= len(seg) {
+			offset -= len(seg)
+			continue
+		}
+		copied += copy(p[copied:], seg[offset:])
+		offset = 0
+		if copied == len(p) {
+			break
+		}
+	}
+	return copied
+}
+
+// IndexByte 从 offset 开始查找 b, 返回相对于缓冲区开头的位置, 没有找到时返回 -1
+func (c *Connect) IndexByte(offset int, b byte) int {
+	base := 0
+	for _, seg := range c.segments() {
+		if offset < base+len(seg) {
+			from := 0
+			if offset > base {
+				from = offset - base
+			}
+			if idx := bytes.IndexByte(seg[from:], b); idx >= 0 {
+				return base + from + idx
+			}
+		}
+		base += len(seg)
+	}
+	return -1
+}
+
+// Frame 返回 [offset, offset+n) 的数据, 数据不够时返回 nil;
+// 数据在同一段中时直接切片, 跨越环形缓冲区的边界时才拷贝到连接的 frameBuf 中;
+// 返回的切片只在这一次 MessageCallback 中有效, 需要保留时要自己拷贝
+func (c *Connect) Frame(offset, n int) []byte {
+	if n < 0 || offset < 0 || offset+n > c.BufferLength() {
+		return nil
+	}
+	base := 0
+	for _, seg := range c.segments() {
+		if offset >= base && offset+n <= base+len(seg) {
+			return seg[offset-base : offset-base+n : offset-base+n]
+		}
+		base += len(seg)
+	}
+
+	if cap(c.frameBuf) < n {
+		c.frameBuf = make([]byte, n)
+	}
+	c.frameBuf = c.frameBuf[:n]
+	c.PeekAt(c.frameBuf, offset)
+	return c.frameBuf
+}
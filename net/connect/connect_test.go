@@ -0,0 +1,192 @@
+package connect
+
+import (
+	"github.com/zput/zput_net_golang/net/event_loop"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// newTestConnect 用 socketpair 创建一个连接, 返回的 fd 是对端
+func newTestConnect(t testing.TB) (*Connect, int) {
+	loop, err := event_loop.New(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// accept4 和 connector 得到的 fd 都是非阻塞的
+	if err = unix.SetNonblock(fds[0], true); err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(loop, fds[0], nil, nil, 0, new(protocol.BuiltInFrameCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.ConnectedHandle(); err != nil {
+		t.Fatal(err)
+	}
+	return c, fds[1]
+}
+
+func TestConnectAttr(t *testing.T) {
+	c, peer := newTestConnect(t)
+	defer unix.Close(peer)
+
+	var (
+		userKey  = NewAttrKey("user")
+		otherKey = NewAttrKey("user")
+	)
+	c.SetContext("session")
+	c.SetAttr(userKey, 42)
+
+	if c.Context() != "session" {
+		t.Fatalf("expect context session, get %v", c.Context())
+	}
+	if v, ok := c.Attr(userKey); !ok || v != 42 {
+		t.Fatalf("expect attr 42, get %v", v)
+	}
+	if _, ok := c.Attr(otherKey); ok {
+		t.Fatal("keys with same name should not collide")
+	}
+	c.DeleteAttr(userKey)
+	if _, ok := c.Attr(userKey); ok {
+		t.Fatal("expect attr deleted")
+	}
+
+	c.SetAttr(userKey, 43)
+	c.SetConnectCloseCallback(func(c *Connect) {
+		// 关闭回调中仍然可以拿到会话状态
+		if c.Context() != "session" {
+			t.Errorf("expect context in close callback, get %v", c.Context())
+		}
+	})
+	c.closeEvent()
+
+	if c.Context() != nil {
+		t.Fatalf("expect context cleared, get %v", c.Context())
+	}
+	if _, ok := c.Attr(userKey); ok {
+		t.Fatal("expect attrs cleared")
+	}
+}
+
+func TestConnectWatermark(t *testing.T) {
+	c, peer := newTestConnect(t)
+	defer unix.Close(peer)
+
+	var high, low []int
+	c.SetHighWatermarkCallback(func(c *Connect, size int) {
+		high = append(high, size)
+	}, 64*1024)
+	c.SetLowWatermarkCallback(func(c *Connect, size int) {
+		low = append(low, size)
+	}, 0)
+
+	// 对端不读, 直到数据进入发送缓冲区并越过高水位
+	data := make([]byte, 16*1024)
+	for i := 0; c.OutBufferLength() < 128*1024; i++ {
+		if i > 1024 {
+			t.Fatal("output buffer does not grow")
+		}
+		c.write(data)
+	}
+	if len(high) != 1 || !c.IsHighWatermark() {
+		t.Fatalf("expect one high watermark callback, get %v", high)
+	}
+
+	// 对端读完之后回落到低水位
+	buf := make([]byte, 64*1024)
+	for c.OutBufferLength() > 0 {
+		if _, err := unix.Read(peer, buf); err != nil {
+			t.Fatal(err)
+		}
+		c.writeEvent()
+	}
+	if len(high) != 1 || len(low) != 1 || low[0] != 0 || c.IsHighWatermark() {
+		t.Fatalf("expect one low watermark callback, get high %v low %v", high, low)
+	}
+}
+
+func TestConnectOutputLimit(t *testing.T) {
+	c, peer := newTestConnect(t)
+	defer unix.Close(peer)
+
+	// 发送缓冲区为空时比上限大的数据也可以直接写到 socket
+	c.SetOutputLimit(1024, protocol.OverflowDrop)
+	if !c.write(make([]byte, 8*1024)) || c.OutBufferLength() != 0 || c.getState() != Connected {
+		t.Fatalf("expect direct write accepted, get buffer %d state %d", c.OutBufferLength(), c.getState())
+	}
+	c.SetOutputLimit(0, protocol.OverflowDrop)
+
+	data := make([]byte, 16*1024)
+	for c.OutBufferLength() == 0 {
+		c.write(data)
+	}
+	c.SetOutputLimit(c.OutBufferLength()+len(data), protocol.OverflowDrop)
+	c.write(data)
+	size := c.OutBufferLength()
+	c.write(data)
+	if c.OutBufferLength() != size || c.getState() != Connected {
+		t.Fatalf("expect write dropped, get buffer %d state %d", c.OutBufferLength(), c.getState())
+	}
+
+	c.SetOutputLimit(size, protocol.OverflowClose)
+	c.write(data)
+	if c.getState() != Disconnected {
+		t.Fatalf("expect connection closed, get state %d", c.getState())
+	}
+}
+
+func TestConnectOutputLimitPartialWrite(t *testing.T) {
+	c, peer := newTestConnect(t)
+	defer unix.Close(peer)
+
+	// 写出一部分之后剩下的超过上限, 不能只丢弃剩下的部分, 关闭连接
+	c.SetOutputLimit(1024, protocol.OverflowDrop)
+	if c.write(make([]byte, 16*1024*1024)) || c.getState() != Disconnected {
+		t.Fatalf("expect connection closed, get state %d", c.getState())
+	}
+}
+
+func TestConnectedHandleFailure(t *testing.T) {
+	loop, err := event_loop.New(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop.Close()
+	// 普通文件不能加入 epoll, Register 失败
+	f, err := ioutil.TempFile("", "connect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fd, err := unix.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	c, err := New(loop, fd, nil, nil, 0, new(protocol.BuiltInFrameCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := false
+	c.SetConnectCloseCallback(func(*Connect) {
+		closed = true
+	})
+	if err = c.ConnectedHandle(); err == nil {
+		t.Fatal("expect register error")
+	}
+	// fd 已经关闭, 也不会回调关闭, 上层只需要归还一次名额
+	if _, err = unix.FcntlInt(uintptr(fd), unix.F_GETFD, 0); err != unix.EBADF {
+		t.Fatalf("expect fd closed, get %v", err)
+	}
+	if closed || c.getState() != Disconnected {
+		t.Fatalf("expect closed without callback, callback %v state %d", closed, c.getState())
+	}
+}
@@ -0,0 +1,61 @@
+package connect
+
+import (
+	"github.com/zput/zput_net_golang/net/log"
+	"github.com/zput/zput_net_golang/net/protocol"
+)
+
+// SetDecodeErrorCallback 解码失败时回调, 按返回的策略处理; 不设置时关闭连接
+func (this *Connect) SetDecodeErrorCallback(cb protocol.OnDecodeErrorCallback) {
+	this.decodeErrorCallback = cb
+}
+
+// SetMaxBufferedBytes 接收缓冲区中还没解码成帧的数据超过 max 时关闭连接, 0 表示不限制
+func (this *Connect) SetMaxBufferedBytes(max int) {
+	this.maxBufferedBytes = max
+}
+
+// CodecContext 满足 protocol.CodecContext, 有状态的 codec 保存在连接上的状态
+func (this *Connect) CodecContext() interface{} {
+	return this.codecContext
+}
+
+func (this *Connect) SetCodecContext(ctx interface{}) {
+	this.codecContext = ctx
+}
+
+// handleDecodeError 返回是否继续解码; before 是 Decode 之前缓冲区的长度, codec 返回错误前可能已经跳过了数据
+func (this *Connect) handleDecodeError(err error, before int) bool {
+	this.metrics.DecodeError()
+
+	policy := protocol.DecodeErrorClose
+	if this.decodeErrorCallback != nil {
+		policy = this.decodeErrorCallback(this, err)
+	}
+
+	switch policy {
+	case protocol.DecodeErrorSkip:
+		// codec 和回调都没有跳过任何数据时整个丢弃, 否则会一直解码失败
+		if this.BufferLength() == before {
+			this.ResetBuffer()
+		}
+		this.logger.Warn("decode failed, skip", log.Err(err), log.F("skipped", before-this.BufferLength()))
+		return true
+	case protocol.DecodeErrorIgnore:
+		this.logger.Warn("decode failed, ignore", log.Err(err))
+		return false
+	default:
+		this.logger.Error("decode failed, close", log.Err(err))
+		this.closeEvent()
+		return false
+	}
+}
+
+// checkMaxBuffered 对端一直发不成帧的数据时关闭连接
+func (this *Connect) checkMaxBuffered() {
+	if this.maxBufferedBytes <= 0 || this.inBuffer.Size() <= this.maxBufferedBytes {
+		return
+	}
+	this.logger.Warn("buffered bytes exceed limit, close", log.F("bytes", this.inBuffer.Size()))
+	this.closeEvent()
+}
@@ -0,0 +1,138 @@
+package connect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/zput/zput_net_golang/net/protocol"
+	"golang.org/x/sys/unix"
+)
+
+var errBadFrame = errors.New("bad frame")
+
+// badLineCodec 按行解码, 以 x 开头的行是坏数据, 不跳过
+type badLineCodec struct {
+	protocol.LineBasedFrameCodec
+}
+
+func (this *badLineCodec) Decode(c protocol.Conn) ([]byte, error) {
+	buf := c.Read()
+	if len(buf) > 0 && buf[0] == 'x' {
+		return nil, errBadFrame
+	}
+	return this.LineBasedFrameCodec.Decode(c)
+}
+
+func newDecodeTestConnect(t *testing.T, cb protocol.OnDecodeErrorCallback) (*Connect, int, *[]string) {
+	c, peer := newTestConnect(t)
+	c.codeImp = new(badLineCodec)
+	c.SetDecodeErrorCallback(cb)
+	frames := new([]string)
+	c.SetMessageCallback(func(c *Connect, data []byte) []byte {
+		*frames = append(*frames, string(data))
+		return nil
+	})
+	return c, peer, frames
+}
+
+func TestConnectDecodeErrorPolicy(t *testing.T) {
+	// 默认关闭连接
+	c, peer, _ := newDecodeTestConnect(t, nil)
+	c.handleData([]byte("x\nok\n"))
+	if c.getState() != Disconnected {
+		t.Fatal("expect connection closed on decode error")
+	}
+	unix.Close(peer)
+
+	// 回调跳过坏的一行, 继续解码后面的帧
+	var gotErr error
+	c, peer, frames := newDecodeTestConnect(t, func(conn protocol.Conn, err error) protocol.DecodeErrorPolicy {
+		gotErr = err
+		conn.ShiftN(bytes.IndexByte(conn.Read(), '\n') + 1)
+		return protocol.DecodeErrorSkip
+	})
+	c.handleData([]byte("x1\nok\nx2\n"))
+	if gotErr != errBadFrame || len(*frames) != 1 || (*frames)[0] != "ok" || c.BufferLength() != 0 {
+		t.Fatalf("expect frame ok after skip, get %v %q", gotErr, *frames)
+	}
+
+	// 没有跳过任何字节时丢弃全部数据
+	c.SetDecodeErrorCallback(func(protocol.Conn, error) protocol.DecodeErrorPolicy {
+		return protocol.DecodeErrorSkip
+	})
+	c.handleData([]byte("xx\nok\n"))
+	if len(*frames) != 1 || c.BufferLength() != 0 || c.getState() == Disconnected {
+		t.Fatalf("expect buffered data dropped, get %q, %d bytes", *frames, c.BufferLength())
+	}
+	unix.Close(peer)
+
+	// 保留数据等待下次
+	c, peer, _ = newDecodeTestConnect(t, func(protocol.Conn, error) protocol.DecodeErrorPolicy {
+		return protocol.DecodeErrorIgnore
+	})
+	defer unix.Close(peer)
+	c.handleData([]byte("xx\n"))
+	if c.getState() == Disconnected || c.BufferLength() != 3 {
+		t.Fatalf("expect data kept, get %d bytes", c.BufferLength())
+	}
+}
+
+func TestConnectMaxBufferedBytes(t *testing.T) {
+	c, peer, frames := newDecodeTestConnect(t, nil)
+	defer unix.Close(peer)
+	c.SetMaxBufferedBytes(8)
+
+	c.handleData([]byte("ok\nabcd"))
+	c.handleData([]byte("efgh"))
+	if c.getState() == Disconnected || len(*frames) != 1 {
+		t.Fatalf("expect connection open with 8 buffered bytes, get %d", c.BufferLength())
+	}
+	c.handleData([]byte("i"))
+	if c.getState() != Disconnected {
+		t.Fatal("expect connection closed after exceeding max buffered bytes")
+	}
+}
+
+func TestConnectDiscardTooLongFrame(t *testing.T) {
+	var tooLong int
+	c, peer, frames := newDecodeTestConnect(t, func(conn protocol.Conn, err error) protocol.DecodeErrorPolicy {
+		if errors.Is(err, protocol.ErrTooLongFrame) {
+			tooLong++
+		}
+		return protocol.DecodeErrorSkip
+	})
+	defer unix.Close(peer)
+	codec := protocol.NewLengthFieldBasedFrameCodec(protocol.EncoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 2,
+	}, protocol.DecoderConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldLength:   2,
+		InitialBytesToStrip: 2,
+		MaxFrameLength:      8,
+		DiscardTooLongFrame: true,
+		FailFast:            true,
+	})
+	c.codeImp = codec
+
+	var data []byte
+	for _, f := range []string{"a", "too long frame", "b"} {
+		out, _ := codec.Encode(c, []byte(f))
+		data = append(data, out...)
+	}
+	// 过长的帧跨两次读
+	c.handleData(data[:5])
+	c.handleData(data[5:])
+	if c.getState() == Disconnected || tooLong != 1 || len(*frames) != 2 || (*frames)[0] != "a" || (*frames)[1] != "b" {
+		t.Fatalf("expect frames a and b around a discarded frame, get %q, %d errors", *frames, tooLong)
+	}
+
+	// 一次读到全部数据, codec 已经丢弃了过长的帧, 后面的 b 不能被一起丢掉
+	*frames = nil
+	c.handleData(data)
+	if c.getState() == Disconnected || tooLong != 2 || len(*frames) != 2 || (*frames)[0] != "a" || (*frames)[1] != "b" {
+		t.Fatalf("expect frames a and b in a single read, get %q, %d errors", *frames, tooLong)
+	}
+}
@@ -0,0 +1,8 @@
+package connect
+
+// PeerCredential unix socket 对端进程的身份
+type PeerCredential struct {
+	Pid int
+	Uid int
+	Gid int
+}
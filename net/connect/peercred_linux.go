@@ -0,0 +1,23 @@
+// +build linux
+
+package connect
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// PeerCred 通过 SO_PEERCRED 获取 unix socket 对端进程的身份
+func (this *Connect) PeerCred() (*PeerCredential, error) {
+	if this.getState() == Disconnected {
+		return nil, ErrConnectionClosed
+	}
+	ucred, err := unix.GetsockoptUcred(this.fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return nil, err
+	}
+	return &PeerCredential{
+		Pid: int(ucred.Pid),
+		Uid: int(ucred.Uid),
+		Gid: int(ucred.Gid),
+	}, nil
+}
@@ -0,0 +1,12 @@
+// +build !linux
+
+package connect
+
+import (
+	"github.com/zput/zput_net_golang/net/protocol"
+)
+
+// PeerCred 目前只支持 linux 的 SO_PEERCRED
+func (this *Connect) PeerCred() (*PeerCredential, error) {
+	return nil, protocol.ErrProtocolNotSupported
+}
@@ -0,0 +1,91 @@
+package connect
+
+import "errors"
+
+// ErrOutputOverflow 超过发送缓冲区上限, 按 OverflowDrop 丢弃了这次发送
+var ErrOutputOverflow = errors.New("output buffer overflow")
+
+// OnSendCallback 一次发送结束时在连接所在的 loop 中回调; err 为 nil 表示数据已经全部写入 socket
+type OnSendCallback func(c *Connect, err error)
+
+// Send 在任意协程发送一条消息, 和 MessageCallback 的返回值一样经过 codec 编码, 在连接所在的 loop 中写出;
+// 发送的顺序和调用的顺序一致, 写出之前不能修改 msg
+func (this *Connect) Send(msg []byte) error {
+	return this.SendWithCallback(msg, nil)
+}
+
+// SendWithCallback 同 Send, 这条消息和之前的数据全部写入 socket, 或者编码失败、连接关闭时回调 cb
+func (this *Connect) SendWithCallback(msg []byte, cb OnSendCallback) error {
+	if !this.sendable() {
+		return ErrConnectionClosed
+	}
+
+	this.loop.RunInLoop(func() {
+		this.sendInLoop(msg, cb)
+	})
+	return nil
+}
+
+// SendAndClose 发送最后一条消息, 写完之后关闭连接; 调用之后其他发送返回 ErrConnectionClosed,
+// 收到的数据不再回调 MessageCallback
+func (this *Connect) SendAndClose(msg []byte) error {
+	if this.getState() != Connected || this.closing.Set(true) {
+		return ErrConnectionClosed
+	}
+
+	this.loop.RunInLoop(func() {
+		this.sendInLoop(msg, nil)
+		this.closeGracefullyInLoop()
+	})
+	return nil
+}
+
+func (this *Connect) sendInLoop(msg []byte, cb OnSendCallback) {
+	if this.getState() == Disconnected {
+		if cb != nil {
+			cb(this, ErrConnectionClosed)
+		}
+		return
+	}
+
+	err := this.encodeAndWrite(msg)
+	if cb == nil {
+		return
+	}
+	switch {
+	case this.getState() == Disconnected:
+		cb(this, ErrConnectionClosed)
+	case err != nil:
+		cb(this, err)
+	case this.writePending():
+		this.sendCallbacks = append(this.sendCallbacks, cb)
+	default:
+		cb(this, nil)
+	}
+}
+
+// writePending 还有数据没有写入 socket, 包括 TLS 握手完成前暂存的明文
+func (this *Connect) writePending() bool {
+	return this.sendPending() || (this.tlsSession != nil && len(this.tlsSession.pending) > 0)
+}
+
+// completeSends 发送缓冲区写完之后调用
+func (this *Connect) completeSends() {
+	this.runSendCallbacks(nil)
+}
+
+// failSends 连接关闭时还没写完的发送都失败
+func (this *Connect) failSends(err error) {
+	this.runSendCallbacks(err)
+}
+
+func (this *Connect) runSendCallbacks(err error) {
+	if len(this.sendCallbacks) == 0 {
+		return
+	}
+	callbacks := this.sendCallbacks
+	this.sendCallbacks = nil
+	for _, cb := range callbacks {
+		cb(this, err)
+	}
+}
@@ -0,0 +1,131 @@
+package connect
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zput/zput_net_golang/net/protocol"
+	"golang.org/x/sys/unix"
+)
+
+func TestConnectSend(t *testing.T) {
+	c, peer := newTestConnect(t)
+	defer unix.Close(peer)
+	c.codeImp = new(protocol.LineBasedFrameCodec)
+
+	// 直接写完时立即回调, 数据经过 codec 编码
+	var results []error
+	record := func(c *Connect, err error) {
+		results = append(results, err)
+	}
+	c.sendInLoop([]byte("hello"), record)
+	buf := make([]byte, 64*1024)
+	if n, err := unix.Read(peer, buf); err != nil || string(buf[:n]) != "hello\n" {
+		t.Fatalf("expect encoded hello, get %q %v", buf[:n], err)
+	}
+	if len(results) != 1 || results[0] != nil {
+		t.Fatalf("expect send completed, get %v", results)
+	}
+
+	// 对端不读, 回调等到发送缓冲区写完
+	payload := bytes.Repeat([]byte("x"), 64*1024)
+	for i := 0; c.OutBufferLength() == 0; i++ {
+		if i > 1024 {
+			t.Fatal("output buffer does not grow")
+		}
+		c.sendInLoop(payload, nil)
+	}
+	c.sendInLoop([]byte("last"), record)
+	if len(results) != 1 {
+		t.Fatal("expect callback after flush")
+	}
+	for c.OutBufferLength() > 0 {
+		if _, err := unix.Read(peer, buf); err != nil && err != unix.EAGAIN {
+			t.Fatal(err)
+		}
+		c.writeEvent()
+	}
+	if len(results) != 2 || results[1] != nil {
+		t.Fatalf("expect send completed after flush, get %v", results)
+	}
+
+	// 超过上限丢弃时回调 ErrOutputOverflow
+	c.SetOutputLimit(1, protocol.OverflowDrop)
+	c.sendInLoop(payload, record)
+	if len(results) != 3 || results[2] != ErrOutputOverflow {
+		t.Fatalf("expect ErrOutputOverflow, get %v", results)
+	}
+}
+
+func TestConnectSendClosed(t *testing.T) {
+	c, peer := newTestConnect(t)
+	defer unix.Close(peer)
+
+	var results []error
+	record := func(c *Connect, err error) {
+		results = append(results, err)
+	}
+	payload := bytes.Repeat([]byte("x"), 64*1024)
+	for i := 0; c.OutBufferLength() == 0; i++ {
+		if i > 1024 {
+			t.Fatal("output buffer does not grow")
+		}
+		c.sendInLoop(payload, nil)
+	}
+	c.sendInLoop(payload, record)
+
+	// 连接关闭时没写完的发送失败, 之后的发送直接失败
+	c.closeEvent()
+	c.sendInLoop(payload, record)
+	if len(results) != 2 || results[0] != ErrConnectionClosed || results[1] != ErrConnectionClosed {
+		t.Fatalf("expect ErrConnectionClosed, get %v", results)
+	}
+	if err := c.Send(payload); err != ErrConnectionClosed {
+		t.Fatalf("expect ErrConnectionClosed, get %v", err)
+	}
+}
+
+func TestConnectSendAndClose(t *testing.T) {
+	c, peer := newTestConnect(t)
+	defer unix.Close(peer)
+	c.codeImp = new(protocol.LineBasedFrameCodec)
+
+	var frames []string
+	c.SetMessageCallback(func(c *Connect, data []byte) []byte {
+		frames = append(frames, string(data))
+		if string(data) == "quit" {
+			if err := c.SendAndClose([]byte("bye")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return data
+	})
+
+	// 回调中调用了 SendAndClose, 同一次读到的后续帧和回调的返回值都不再处理
+	c.handleData([]byte("a\nquit\nb\n"))
+	if len(frames) != 2 || frames[0] != "a" || frames[1] != "quit" {
+		t.Fatalf("expect frames a and quit, get %q", frames)
+	}
+	buf := make([]byte, 64)
+	if n, err := unix.Read(peer, buf); err != nil || string(buf[:n]) != "a\n" {
+		t.Fatalf("expect only reply a, get %q %v", buf[:n], err)
+	}
+
+	// 最后的消息还在排队, 之后的发送都失败, 收到的数据也不再回调
+	if err := c.Send([]byte("late")); err != ErrConnectionClosed {
+		t.Fatalf("Send expect ErrConnectionClosed, get %v", err)
+	}
+	if err := c.WriteInSelfLoop([]byte("late")); err != ErrConnectionClosed {
+		t.Fatalf("WriteInSelfLoop expect ErrConnectionClosed, get %v", err)
+	}
+	if err := c.WriteFrames([][]byte{[]byte("late")}); err != ErrConnectionClosed {
+		t.Fatalf("WriteFrames expect ErrConnectionClosed, get %v", err)
+	}
+	if err := c.SendAndClose([]byte("bye")); err != ErrConnectionClosed {
+		t.Fatalf("SendAndClose expect ErrConnectionClosed, get %v", err)
+	}
+	c.handleData([]byte("c\n"))
+	if len(frames) != 2 {
+		t.Fatalf("expect no frames after SendAndClose, get %q", frames)
+	}
+}
@@ -0,0 +1,140 @@
+package connect
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/zput/zput_net_golang/net/log"
+	"golang.org/x/sys/unix"
+)
+
+// ErrSendFileTLS TLS 连接需要在用户态加密, 不能用 sendfile
+var ErrSendFileTLS = errors.New("sendfile is not supported on tls connections")
+
+// sendItem 排在 outBuffer 后面等待发送的文件片段或者数据, file 为 nil 时是数据
+type sendItem struct {
+	data      []byte
+	file      *os.File
+	fd        int
+	offset    int64
+	remaining int64
+}
+
+// SendFile 用 sendfile 发送文件的 [offset, offset+length), 文件内容不经过用户态;
+// 发送缓冲区还有数据时排在后面, 之后写入的数据排在文件后面; 整个文件发完后回调 WriteCompletCallback.
+// 可以在任意协程调用, 回调之前(或者连接关闭之前)不能关闭 f
+func (this *Connect) SendFile(f *os.File, offset, length int64) error {
+	if !this.sendable() {
+		return ErrConnectionClosed
+	}
+	if this.tlsSession != nil {
+		return ErrSendFileTLS
+	}
+	if length <= 0 {
+		return nil
+	}
+
+	item := &sendItem{file: f, fd: int(f.Fd()), offset: offset, remaining: length}
+	this.loop.RunInLoop(func() {
+		this.sendFileInLoop(item)
+	})
+	return nil
+}
+
+func (this *Connect) sendFileInLoop(item *sendItem) {
+	if this.getState() == Disconnected {
+		return
+	}
+	if !this.sendPending() {
+		done, err := this.sendFileSegment(item)
+		if err != nil {
+			this.logger.Error("sendfile failed", log.Err(err))
+			this.closeEvent()
+			return
+		}
+		if done {
+			this.completeSends()
+			if this.writeCompleteCallback != nil {
+				this.writeCompleteCallback(this)
+			}
+			return
+		}
+	}
+	this.sendQueue = append(this.sendQueue, item)
+	_ = this.event.EnableWriting(true)
+}
+
+// sendFileSegment 一直发送到文件片段发完或者 EAGAIN, 返回是否发完
+func (this *Connect) sendFileSegment(item *sendItem) (bool, error) {
+	for item.remaining > 0 {
+		n, err := sendfile(this.fd, item.fd, &item.offset, item.remaining)
+		if n > 0 {
+			item.remaining -= int64(n)
+			this.metrics.Written(n)
+		}
+		if err == unix.EAGAIN {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if n == 0 {
+			// 文件比 length 短
+			return false, io.ErrUnexpectedEOF
+		}
+	}
+	return true, nil
+}
+
+// queueData 数据拷贝一份排到队尾, 和前一段数据合并
+func (this *Connect) queueData(frames [][]byte, total int) {
+	var last *sendItem
+	if n := len(this.sendQueue); n > 0 && this.sendQueue[n-1].file == nil {
+		last = this.sendQueue[n-1]
+	} else {
+		last = &sendItem{data: make([]byte, 0, total)}
+		this.sendQueue = append(this.sendQueue, last)
+	}
+	for _, frame := range frames {
+		last.data = append(last.data, frame...)
+	}
+	this.queuedBytes += total
+	this.metrics.Buffered(total)
+}
+
+// flushSendQueue outBuffer 写完之后调用; 数据搬进 outBuffer 后返回 true, 由 writeEvent 继续 writev;
+// 文件遇到 EAGAIN、连接关闭或者队列发完时返回 false
+func (this *Connect) flushSendQueue() bool {
+	for len(this.sendQueue) > 0 {
+		item := this.sendQueue[0]
+		if item.file == nil {
+			_, _ = this.outBuffer.Write(item.data)
+			this.queuedBytes -= len(item.data)
+			this.popSendQueue()
+			return true
+		}
+
+		done, err := this.sendFileSegment(item)
+		if err != nil {
+			this.logger.Error("sendfile failed", log.Err(err))
+			this.closeEvent()
+			return false
+		}
+		if !done {
+			return false
+		}
+		this.popSendQueue()
+	}
+	return false
+}
+
+func (this *Connect) popSendQueue() {
+	this.sendQueue[0] = nil
+	this.sendQueue = this.sendQueue[1:]
+}
+
+// sendPending 发送缓冲区或者发送队列中还有数据
+func (this *Connect) sendPending() bool {
+	return !this.outBuffer.IsEmpty() || len(this.sendQueue) > 0
+}
@@ -0,0 +1,16 @@
+// +build linux
+
+package connect
+
+import "golang.org/x/sys/unix"
+
+// maxSendfileSize linux 一次 sendfile 最多发送 0x7ffff000 字节
+const maxSendfileSize = 1 << 30
+
+// sendfile 从 inFd 的 *offset 开始发送, 成功后 offset 向后移动
+func sendfile(outFd, inFd int, offset *int64, count int64) (int, error) {
+	if count > maxSendfileSize {
+		count = maxSendfileSize
+	}
+	return unix.Sendfile(outFd, inFd, offset, int(count))
+}
@@ -0,0 +1,26 @@
+// +build !linux
+
+package connect
+
+import "golang.org/x/sys/unix"
+
+// sendfileChunk 没有 linux sendfile 语义的平台上每次 pread 再 write 的大小
+const sendfileChunk = 64 * 1024
+
+// sendfile 用 pread + write 模拟, 成功后 offset 向后移动
+func sendfile(outFd, inFd int, offset *int64, count int64) (int, error) {
+	if count > sendfileChunk {
+		count = sendfileChunk
+	}
+	buf := make([]byte, count)
+	n, err := unix.Pread(inFd, buf, *offset)
+	if n <= 0 {
+		return 0, err
+	}
+	written, err := unix.Write(outFd, buf[:n])
+	if written < 0 {
+		written = 0
+	}
+	*offset += int64(written)
+	return written, err
+}
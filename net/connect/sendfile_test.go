@@ -0,0 +1,85 @@
+package connect
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func newTestFile(t *testing.T, size int) (*os.File, []byte) {
+	data := make([]byte, size)
+	rand.Read(data)
+	f, err := ioutil.TempFile("", "sendfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	return f, data
+}
+
+func TestConnectSendFileQueue(t *testing.T) {
+	c, peer := newTestConnect(t)
+	defer unix.Close(peer)
+	f, content := newTestFile(t, 512*1024)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	var completes int
+	c.SetWriteCompleteCallback(func(c *Connect) {
+		completes++
+	})
+
+	// 对端不读, 先把 outBuffer 写出积压, 文件排在后面, 之后的数据排在文件后面
+	var expect []byte
+	pre := bytes.Repeat([]byte("p"), 16*1024)
+	for c.OutBufferLength() == 0 {
+		c.write(pre)
+		expect = append(expect, pre...)
+	}
+	c.sendFileInLoop(&sendItem{file: f, fd: int(f.Fd()), offset: 100, remaining: int64(len(content) - 200)})
+	expect = append(expect, content[100:len(content)-100]...)
+	c.write([]byte("post"))
+	expect = append(expect, "post"...)
+	if len(c.sendQueue) != 2 || c.queuedBytes != 4 {
+		t.Fatalf("expect file and data queued, get %d items %d bytes", len(c.sendQueue), c.queuedBytes)
+	}
+
+	var got []byte
+	buf := make([]byte, 64*1024)
+	for len(got) < len(expect) {
+		n, err := unix.Read(peer, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[:n]...)
+		if c.sendPending() {
+			c.writeEvent()
+		}
+	}
+	if !bytes.Equal(got, expect) {
+		t.Fatal("file is not sent in order")
+	}
+	if completes != 1 || c.sendPending() || c.OutBufferLength() != 0 {
+		t.Fatalf("expect one write complete callback, get %d", completes)
+	}
+}
+
+func TestConnectSendFileShort(t *testing.T) {
+	c, peer := newTestConnect(t)
+	defer unix.Close(peer)
+	f, content := newTestFile(t, 1024)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// 文件比要求的长度短, 发完已有的内容后关闭连接
+	c.sendFileInLoop(&sendItem{file: f, fd: int(f.Fd()), remaining: int64(len(content) + 1)})
+	if c.getState() != Disconnected {
+		t.Fatal("expect connection closed on short file")
+	}
+}
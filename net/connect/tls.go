@@ -0,0 +1,359 @@
+package connect
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/zput/zput_net_golang/net/log"
+)
+
+// tlsReadBufferSize 一次解密出来的明文上限, 和 TLS 单个记录的大小一致
+const tlsReadBufferSize = 16 * 1024
+
+// OnHandshakeCallback TLS 握手完成
+type OnHandshakeCallback func(*Connect)
+
+// tlsMaxHandshakeInput 握手期间 in 中等待握手协程读取的密文上限, 超过后暂停读 socket
+const tlsMaxHandshakeInput = 64 * 1024
+
+// errTLSWouldBlock 握手完成后 in 为空时 Read 返回, crypto/tls 对 Temporary 的错误不会记为永久错误, 下次可以继续读
+var errTLSWouldBlock net.Error = wouldBlockError{}
+
+type wouldBlockError struct{}
+
+func (wouldBlockError) Error() string   { return "tls: would block" }
+func (wouldBlockError) Timeout() bool   { return true }
+func (wouldBlockError) Temporary() bool { return true }
+
+// memConn 连接 crypto/tls 与 loop:
+// crypto/tls 的握手不能在 EAGAIN 之后继续, 只有握手在单独的协程中阻塞读取 in;
+// 握手完成后由 loop 在读写事件中直接调用 tls.Conn, in 为空时 Read 返回 errTLSWouldBlock。
+// tls 写出的密文按记录顺序追加到 out, 由 loop 通过发送缓冲区写到 socket。
+type memConn struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	in     []byte
+	closed bool
+	// 握手完成, 之后只有 loop 访问 tls.Conn
+	loopDriven bool
+	// 握手期间 in 达到上限, loop 停止读 socket, 握手协程读空 in 时回调 onResume
+	paused   bool
+	onResume func()
+
+	outMutex sync.Mutex
+	out      []byte
+	// 握手协程写出密文时通知 loop
+	onOutput func()
+
+	localAddr, remoteAddr net.Addr
+}
+
+func newMemConn() *memConn {
+	var m memConn
+	m.cond = sync.NewCond(&m.mutex)
+	return &m
+}
+
+// feed 返回 true 表示握手期间 in 已满, 需要暂停读 socket
+func (this *memConn) feed(data []byte) bool {
+	this.mutex.Lock()
+	this.in = append(this.in, data...)
+	full := !this.loopDriven && len(this.in) >= tlsMaxHandshakeInput
+	if full {
+		this.paused = true
+	}
+	this.mutex.Unlock()
+	this.cond.Signal()
+	return full
+}
+
+func (this *memConn) Read(p []byte) (int, error) {
+	this.mutex.Lock()
+	for len(this.in) == 0 && !this.closed && !this.loopDriven {
+		this.cond.Wait()
+	}
+	if len(this.in) == 0 {
+		closed := this.closed
+		this.mutex.Unlock()
+		if closed {
+			return 0, io.EOF
+		}
+		return 0, errTLSWouldBlock
+	}
+	n := copy(p, this.in)
+	this.in = this.in[n:]
+	resume := false
+	if len(this.in) == 0 {
+		this.in = nil
+		resume = this.paused
+		this.paused = false
+	}
+	this.mutex.Unlock()
+
+	if resume {
+		this.onResume()
+	}
+	return n, nil
+}
+
+func (this *memConn) Write(p []byte) (int, error) {
+	this.outMutex.Lock()
+	this.out = append(this.out, p...)
+	this.outMutex.Unlock()
+
+	// loop 自己调用 tls.Conn 时写完会立即 flush
+	this.mutex.Lock()
+	loopDriven := this.loopDriven
+	this.mutex.Unlock()
+	if !loopDriven {
+		this.onOutput()
+	}
+	return len(p), nil
+}
+
+// setLoopDriven 握手协程退出之后在 loop 中调用
+func (this *memConn) setLoopDriven() {
+	this.mutex.Lock()
+	this.loopDriven = true
+	this.paused = false
+	this.mutex.Unlock()
+	this.cond.Broadcast()
+}
+
+func (this *memConn) takeOutput() []byte {
+	this.outMutex.Lock()
+	defer this.outMutex.Unlock()
+	out := this.out
+	this.out = nil
+	return out
+}
+
+func (this *memConn) Close() error {
+	this.mutex.Lock()
+	this.closed = true
+	this.mutex.Unlock()
+	this.cond.Broadcast()
+	return nil
+}
+
+func (this *memConn) LocalAddr() net.Addr                { return this.localAddr }
+func (this *memConn) RemoteAddr() net.Addr               { return this.remoteAddr }
+func (this *memConn) SetDeadline(t time.Time) error      { return nil }
+func (this *memConn) SetReadDeadline(t time.Time) error  { return nil }
+func (this *memConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// tlsSession 除了握手协程, 其余字段只在 loop 中访问
+type tlsSession struct {
+	mem  *memConn
+	conn *tls.Conn
+	// 解密出来的明文, 握手完成后分配
+	buf []byte
+
+	handshakeTimeout time.Duration
+	handshakeDone    bool
+	state            tls.ConnectionState
+	// 握手完成之前上层写的明文
+	pending [][]byte
+}
+
+// EnableTLS 在 ConnectedHandle 之前调用; isClient 为 true 时作为 TLS 客户端发起握手。
+// 握手超过 handshakeTimeout 仍未完成时关闭连接, 0 表示不限制。
+func (this *Connect) EnableTLS(config *tls.Config, isClient bool, handshakeTimeout time.Duration) {
+	mem := newMemConn()
+	mem.onOutput = func() {
+		this.loop.RunInLoop(this.flushTLSOutput)
+	}
+	mem.onResume = func() {
+		this.loop.RunInLoop(this.resumeTLSRead)
+	}
+
+	var conn *tls.Conn
+	if isClient {
+		conn = tls.Client(mem, config)
+	} else {
+		conn = tls.Server(mem, config)
+	}
+	this.tlsSession = &tlsSession{
+		mem:              mem,
+		conn:             conn,
+		handshakeTimeout: handshakeTimeout,
+	}
+}
+
+func (this *Connect) SetHandshakeCallback(handshakeCallback OnHandshakeCallback) {
+	this.handshakeCallback = handshakeCallback
+}
+
+// IsTLS 是否开启了 TLS
+func (this *Connect) IsTLS() bool {
+	return this.tlsSession != nil
+}
+
+// TLSState 握手完成后的状态(SNI 的 ServerName、ALPN 协商出的 NegotiatedProtocol 等),
+// 未开启 TLS 或者握手未完成时返回 nil; 需要在 loop 中调用, 例如 ConnectCallback
+func (this *Connect) TLSState() *tls.ConnectionState {
+	if this.tlsSession == nil || !this.tlsSession.handshakeDone {
+		return nil
+	}
+	return &this.tlsSession.state
+}
+
+// startTLS 在 ConnectedHandle 中调用
+func (this *Connect) startTLS() {
+	session := this.tlsSession
+	if session.handshakeTimeout > 0 && this.timingWheel != nil {
+		this.timingWheel.AfterFunc(session.handshakeTimeout, func() {
+			this.loop.RunInLoop(this.checkHandshakeTimeout)
+		})
+	}
+	go this.tlsHandshake(session)
+}
+
+// tlsHandshake 在单独的协程中握手, 握手结束协程就退出, 之后记录的加解密都在 loop 中
+func (this *Connect) tlsHandshake(session *tlsSession) {
+	if err := session.conn.Handshake(); err != nil {
+		this.logger.Error("tls handshake failed", log.Err(err))
+		this.loop.RunInLoop(func() {
+			this.closeTLSConnect(session)
+		})
+		return
+	}
+	state := session.conn.ConnectionState()
+	this.loop.RunInLoop(func() {
+		this.tlsHandshakeDone(session, state)
+	})
+}
+
+func (this *Connect) tlsHandshakeDone(session *tlsSession, state tls.ConnectionState) {
+	if this.getState() == Disconnected || this.tlsSession != session {
+		return
+	}
+	session.mem.setLoopDriven()
+	session.buf = make([]byte, tlsReadBufferSize)
+	session.handshakeDone = true
+	session.state = state
+
+	pending := session.pending
+	session.pending = nil
+	for _, data := range pending {
+		this.tlsWrite(data)
+	}
+	if this.getState() == Disconnected {
+		return
+	}
+	if !this.sendPending() {
+		this.completeSends()
+		// 握手期间调用了 CloseGracefully/SendAndClose/ShutdownWrite, 暂存的数据已经写完;
+		// 没写完时由 writeEvent 完成
+		this.closeIfDrained()
+		if this.getState() == Disconnected {
+			return
+		}
+	}
+
+	if this.handshakeCallback != nil {
+		this.handshakeCallback(this)
+	}
+	if this.getState() == Disconnected {
+		return
+	}
+	// 握手期间已经收到的应用数据, 以及可能暂停了的读
+	this.readTLSRecords(session)
+	this.resumeTLSRead()
+}
+
+// tlsRead 在 readEvent 中处理读到的密文, 返回 false 表示暂停读 socket
+func (this *Connect) tlsRead(data []byte) bool {
+	session := this.tlsSession
+	if session.mem.feed(data) {
+		if err := this.event.EnableReading(false); err != nil {
+			this.logger.Error("disable reading failed", log.Err(err))
+		}
+		return false
+	}
+	if session.handshakeDone {
+		this.readTLSRecords(session)
+	}
+	return true
+}
+
+// readTLSRecords 解密 in 中所有完整的记录, 不完整的记录留在 tls.Conn 中等待下次读
+func (this *Connect) readTLSRecords(session *tlsSession) {
+	for {
+		n, err := session.conn.Read(session.buf)
+		if n > 0 {
+			this.handleData(session.buf[:n])
+			if this.getState() == Disconnected || this.tlsSession != session {
+				return
+			}
+		}
+		if err == errTLSWouldBlock {
+			break
+		}
+		if err != nil {
+			if err != io.EOF {
+				this.logger.Error("tls read failed", log.Err(err))
+			}
+			this.closeTLSConnect(session)
+			return
+		}
+	}
+	// 读到 KeyUpdate 等握手后消息时 tls 会写出回应
+	this.flushTLSOutput()
+}
+
+// resumeTLSRead 握手协程读空 in 之后恢复读 socket; 发送缓冲区还有数据时由 writeEvent 恢复
+func (this *Connect) resumeTLSRead() {
+	if this.getState() == Disconnected || this.event.IsReading() || this.sendPending() {
+		return
+	}
+	if err := this.event.EnableReading(true); err != nil {
+		this.logger.Error("enable reading failed", log.Err(err))
+	}
+}
+
+func (this *Connect) closeTLSConnect(session *tlsSession) {
+	if this.tlsSession != session {
+		return
+	}
+	// 握手失败时把 alert 发出去
+	this.flushTLSOutput()
+	this.closeEvent()
+}
+
+func (this *Connect) checkHandshakeTimeout() {
+	if this.getState() == Disconnected || this.tlsSession.handshakeDone {
+		return
+	}
+	this.logger.Error("tls handshake timeout")
+	this.closeEvent()
+}
+
+// tlsWrite 明文加密后写入发送缓冲区; 握手完成前先暂存
+func (this *Connect) tlsWrite(data []byte) {
+	session := this.tlsSession
+	if !session.handshakeDone {
+		session.pending = append(session.pending, append([]byte(nil), data...))
+		return
+	}
+
+	_, err := session.conn.Write(data)
+	if err != nil {
+		this.logger.Error("tls write failed", log.Err(err))
+		this.closeEvent()
+		return
+	}
+	this.flushTLSOutput()
+}
+
+func (this *Connect) flushTLSOutput() {
+	if this.getState() == Disconnected {
+		return
+	}
+	if out := this.tlsSession.mem.takeOutput(); len(out) > 0 {
+		this.writeRaw(out)
+	}
+}
@@ -0,0 +1,44 @@
+package connect
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestMemConnHandshakeInputLimit(t *testing.T) {
+	m := newMemConn()
+	resumed := 0
+	m.onResume = func() {
+		resumed++
+	}
+
+	// 握手期间 in 达到上限时要求暂停读, 握手协程读空之后恢复
+	chunk := bytes.Repeat([]byte("x"), tlsMaxHandshakeInput/2)
+	if m.feed(chunk) {
+		t.Fatal("expect not full")
+	}
+	if !m.feed(chunk) {
+		t.Fatal("expect full")
+	}
+	buf := make([]byte, tlsMaxHandshakeInput)
+	if n, err := io.ReadFull(m, buf); err != nil || n != len(buf) || resumed != 1 {
+		t.Fatalf("expect resume after drain, get %d %v, resumed %d", n, err, resumed)
+	}
+
+	// 握手完成后由 loop 读, in 为空时不阻塞, 也不再限制
+	m.setLoopDriven()
+	if _, err := m.Read(buf); err != errTLSWouldBlock {
+		t.Fatalf("expect errTLSWouldBlock, get %v", err)
+	}
+	if m.feed(buf) {
+		t.Fatal("expect no limit after handshake")
+	}
+	_ = m.Close()
+	if n, err := m.Read(buf); n != len(buf) || err != nil {
+		t.Fatalf("expect buffered data before EOF, get %d %v", n, err)
+	}
+	if _, err := m.Read(buf); err != io.EOF {
+		t.Fatalf("expect EOF, get %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package connect
+
+import (
+	"github.com/zput/zput_net_golang/net/log"
+	"github.com/zput/zput_net_golang/net/protocol"
+)
+
+// OnWatermarkCallback 发送缓冲区越过高/低水位, size 为当前发送缓冲区大小
+type OnWatermarkCallback func(c *Connect, size int)
+
+// SetHighWatermarkCallback 发送缓冲区从低于 mark 增长到 >= mark 时回调一次, 直到回落到低水位
+func (this *Connect) SetHighWatermarkCallback(highWatermarkCallback OnWatermarkCallback, mark int) {
+	this.highWatermarkCallback = highWatermarkCallback
+	this.highWatermark = mark
+}
+
+// SetLowWatermarkCallback 越过高水位之后, 发送缓冲区回落到 <= mark 时回调一次
+func (this *Connect) SetLowWatermarkCallback(lowWatermarkCallback OnWatermarkCallback, mark int) {
+	this.lowWatermarkCallback = lowWatermarkCallback
+	this.lowWatermark = mark
+}
+
+// SetOutputLimit 发送缓冲区上限, 写入后超过 max 时按 policy 关闭连接或丢弃这次写入; max <= 0 表示不限制
+func (this *Connect) SetOutputLimit(max int, policy protocol.OverflowPolicy) {
+	this.maxOutputBuffer = max
+	this.overflowPolicy = policy
+}
+
+// IsHighWatermark 发送缓冲区是否处于高水位, 可以在任意协程调用, 用来暂停向慢连接推送
+func (this *Connect) IsHighWatermark() bool {
+	return this.aboveHighWatermark.Get()
+}
+
+// OutBufferLength 发送缓冲区中等待写入 socket 的字节数, 包括排在文件后面的数据, 不包括文件; 需要在 loop 中调用
+func (this *Connect) OutBufferLength() int {
+	return this.outBuffer.Size() + this.queuedBytes
+}
+
+// outputOverflow 还要放进发送缓冲区的 n 个字节是否超过上限, written 为这次已经直接写到 socket 的字节数;
+// 超过时已经按策略处理
+func (this *Connect) outputOverflow(n int, written int) bool {
+	if this.maxOutputBuffer <= 0 || this.OutBufferLength()+n <= this.maxOutputBuffer {
+		return false
+	}
+
+	// TLS 记录和已经写出一部分的数据不能丢弃剩下的部分, 只能关闭连接
+	if this.overflowPolicy == protocol.OverflowDrop && this.tlsSession == nil && written == 0 {
+		this.logger.Warn("output buffer overflow, drop", log.F("bytes", n))
+		return true
+	}
+	this.logger.Warn("output buffer overflow, close")
+	this.closeEvent()
+	return true
+}
+
+// checkHighWatermark 数据放进发送缓冲区之后调用
+func (this *Connect) checkHighWatermark() {
+	if this.highWatermark <= 0 || this.aboveHighWatermark.Get() {
+		return
+	}
+	if size := this.OutBufferLength(); size >= this.highWatermark {
+		this.aboveHighWatermark.Set(true)
+		if this.highWatermarkCallback != nil {
+			this.highWatermarkCallback(this, size)
+		}
+	}
+}
+
+// checkLowWatermark 发送缓冲区写出一部分之后调用
+func (this *Connect) checkLowWatermark() {
+	if !this.aboveHighWatermark.Get() {
+		return
+	}
+	if size := this.OutBufferLength(); size <= this.lowWatermark {
+		this.aboveHighWatermark.Set(false)
+		if this.lowWatermarkCallback != nil {
+			this.lowWatermarkCallback(this, size)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package connect
+
+import (
+	"github.com/zput/zput_net_golang/net/log"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/worker"
+)
+
+// SetWorkerPool MessageCallback 交给 pool 执行, 这个连接的帧按顺序处理; 需要在 ConnectedHandle 之前调用
+func (this *Connect) SetWorkerPool(pool *worker.Pool, policy protocol.WorkerRejectPolicy) {
+	this.mailbox = pool.NewMailbox()
+	this.mailbox.SetIdleCallback(func() {
+		this.loop.RunInLoop(this.workerDrained)
+	})
+	this.workerRejectPolicy = policy
+}
+
+// workerIdle 没有交给 worker 的帧还在排队或者执行
+func (this *Connect) workerIdle() bool {
+	return this.mailbox == nil || this.mailbox.Idle()
+}
+
+// workerDrained worker 处理完这个连接的所有帧, 之前的回复已经在 loop 中写出;
+// 完成等待 worker 的 CloseGracefully/ShutdownWrite, 还有数据没写完时由 writeEvent 完成
+func (this *Connect) workerDrained() {
+	if this.getState() == Disconnected {
+		return
+	}
+	this.closeIfDrained()
+}
+
+// closeIfDrained 数据全部写出、worker 也处理完之后完成等待中的 CloseGracefully/ShutdownWrite
+func (this *Connect) closeIfDrained() {
+	// TLS 握手期间 outBuffer 写完的是握手数据, 暂存的明文还没写; worker 还在处理时等 workerDrained
+	if this.writePending() || !this.workerIdle() {
+		return
+	}
+	if this.closeAfterFlush {
+		this.closeEvent()
+	} else if this.getState() == Disconnecting {
+		this.shutdownWriteInLoop()
+	}
+}
+
+// dispatch 把帧交给 worker, 返回是否继续解码
+func (this *Connect) dispatch(inFrame []byte) bool {
+	// 解码得到的帧在下一次 Decode 之后失效, worker 中使用要先拷贝
+	frame := make([]byte, len(inFrame))
+	copy(frame, inFrame)
+
+	err := this.mailbox.Submit(func() {
+		this.handleFrame(frame)
+	}, this.workerRejectPolicy == protocol.WorkerRejectBlock)
+	if err == nil {
+		return true
+	}
+
+	switch this.workerRejectPolicy {
+	case protocol.WorkerRejectDrop:
+		this.logger.Warn("worker queue full, drop frame", log.Err(err), log.F("bytes", len(frame)))
+		return true
+	default:
+		this.logger.Error("worker queue full, close", log.Err(err))
+		this.closeEvent()
+		return false
+	}
+}
+
+// handleFrame 在 worker 协程中执行, 返回的数据回到 loop 中编码写出, 和同步处理时的顺序一致
+func (this *Connect) handleFrame(frame []byte) {
+	out := this.messageCallback(this, frame)
+	if out == nil {
+		return
+	}
+	this.loop.RunInLoop(func() {
+		this.sendInLoop(out, nil)
+	})
+}
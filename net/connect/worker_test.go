@@ -0,0 +1,74 @@
+package connect
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/worker"
+	"golang.org/x/sys/unix"
+)
+
+func TestConnectWorkerPool(t *testing.T) {
+	c, peer := newTestConnect(t)
+	defer unix.Close(peer)
+	c.codeImp = new(protocol.LineBasedFrameCodec)
+
+	pool := worker.New(2, 0)
+	pool.Start()
+	defer pool.Stop()
+	c.SetWorkerPool(pool, protocol.WorkerRejectClose)
+
+	frames := make(chan string, 3)
+	c.SetMessageCallback(func(c *Connect, data []byte) []byte {
+		frames <- string(data)
+		return nil
+	})
+	c.handleData([]byte("a\nb\nc\n"))
+	for _, want := range []string{"a", "b", "c"} {
+		select {
+		case got := <-frames:
+			if got != want {
+				t.Fatalf("expect frame %q, get %q", want, got)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("wait frame %q timeout", want)
+		}
+	}
+}
+
+func TestConnectWorkerPoolReject(t *testing.T) {
+	pool := worker.New(1, 1)
+	pool.Start()
+	defer pool.Stop()
+
+	release := make(chan struct{})
+	defer close(release)
+	newConnect := func(policy protocol.WorkerRejectPolicy) (*Connect, int, *int) {
+		c, peer := newTestConnect(t)
+		c.codeImp = new(protocol.LineBasedFrameCodec)
+		c.SetWorkerPool(pool, policy)
+		count := new(int)
+		c.SetMessageCallback(func(c *Connect, data []byte) []byte {
+			*count++
+			<-release
+			return nil
+		})
+		return c, peer, count
+	}
+
+	// 第一帧占满队列, 第二帧被丢弃, 连接保持
+	c, peer, _ := newConnect(protocol.WorkerRejectDrop)
+	defer unix.Close(peer)
+	c.handleData([]byte("a\nb\n"))
+	if c.getState() == Disconnected || c.BufferLength() != 0 {
+		t.Fatalf("expect frame dropped and connection kept, %d bytes left", c.BufferLength())
+	}
+
+	c, peer, _ = newConnect(protocol.WorkerRejectClose)
+	defer unix.Close(peer)
+	c.handleData([]byte("a\n"))
+	if c.getState() != Disconnected {
+		t.Fatal("expect connection closed when queue full")
+	}
+}
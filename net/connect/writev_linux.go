@@ -0,0 +1,38 @@
+// +build linux
+
+package connect
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxIovecs 一次 writev 最多的缓冲区个数, 多出来的当作没写完, 下一次再写
+const maxIovecs = 64
+
+// writev 一次系统调用写出多个缓冲区; iovec 在栈上, 不分配内存
+func writev(fd int, bufs [][]byte) (int, error) {
+	var iovecs [maxIovecs]unix.Iovec
+	count := 0
+	for _, buf := range bufs {
+		if len(buf) == 0 {
+			continue
+		}
+		if count == maxIovecs {
+			break
+		}
+		iovecs[count].Base = &buf[0]
+		iovecs[count].SetLen(len(buf))
+		count++
+	}
+	if count == 0 {
+		return 0, nil
+	}
+
+	n, _, errno := unix.Syscall(unix.SYS_WRITEV, uintptr(fd), uintptr(unsafe.Pointer(&iovecs[0])), uintptr(count))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
@@ -0,0 +1,27 @@
+// +build !linux
+
+package connect
+
+import "golang.org/x/sys/unix"
+
+// writev 没有 writev 封装的平台上逐个 write, 写不完就停下
+func writev(fd int, bufs [][]byte) (int, error) {
+	written := 0
+	for _, buf := range bufs {
+		if len(buf) == 0 {
+			continue
+		}
+		n, err := unix.Write(fd, buf)
+		if err != nil {
+			if written > 0 && err == unix.EAGAIN {
+				return written, nil
+			}
+			return written, err
+		}
+		written += n
+		if n < len(buf) {
+			break
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,50 @@
+package connect
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestConnectWriteFrames(t *testing.T) {
+	c, peer := newTestConnect(t)
+	defer unix.Close(peer)
+
+	c.writeFrames([][]byte{[]byte("head"), nil, []byte("body")})
+	buf := make([]byte, 64*1024)
+	if n, err := unix.Read(peer, buf); err != nil || string(buf[:n]) != "headbody" {
+		t.Fatalf("expect headbody, get %q %v", buf[:n], err)
+	}
+
+	// 对端不读, 写不完的部分按顺序进入发送缓冲区, 之后 writeEvent 用 writev 写出两段
+	var expect []byte
+	for i := 0; c.OutBufferLength() < 256*1024; i++ {
+		if i > 4096 {
+			t.Fatal("output buffer does not grow")
+		}
+		header := []byte{byte(i), byte(i >> 8), '|'}
+		payload := bytes.Repeat([]byte{byte(i * 7)}, 5000+i%3)
+		expect = append(append(expect, header...), payload...)
+		c.writeFrames([][]byte{header, payload})
+	}
+
+	var got []byte
+	for len(got) < len(expect) {
+		n, err := unix.Read(peer, buf)
+		if err != nil && err != unix.EAGAIN {
+			t.Fatal(err)
+		}
+		if n > 0 {
+			got = append(got, buf[:n]...)
+		}
+		if c.OutBufferLength() > 0 {
+			c.writeEvent()
+		} else if err == unix.EAGAIN {
+			t.Fatalf("expect %d bytes, get %d", len(expect), len(got))
+		}
+	}
+	if !bytes.Equal(got, expect) {
+		t.Fatal("frames are written out of order")
+	}
+}
@@ -0,0 +1,362 @@
+package connector
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/RussellLuo/timingwheel"
+	"github.com/zput/zput_net_golang/net/event_loop"
+	"github.com/zput/zput_net_golang/net/log"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"golang.org/x/sys/unix"
+)
+
+// OnConnectErrorCallback 连接失败时回调
+type OnConnectErrorCallback func(err error)
+
+type ConnectorState int
+
+const (
+	Disconnected ConnectorState = 1
+	Connecting   ConnectorState = 2
+	Connected    ConnectorState = 3
+)
+
+var ErrSelfConnect = errors.New("self connect")
+
+// defaultLogger 没有调用 SetLogger 时使用
+var defaultLogger = log.Default()
+
+// Connector 主动发起非阻塞 connect(2), 连接建立后把 fd 交给上层
+type Connector struct {
+	loop   *event_loop.EventLoop
+	event  *event_loop.Event
+	domain int
+	sa     unix.Sockaddr
+	state  ConnectorState
+
+	// 是否需要连接, Stop 之后置为 false
+	wantConnect protocol.Bool
+
+	// 重连配置, 为 nil 时失败后不再重试
+	retry       *protocol.Backoff
+	timingWheel *timingwheel.TimingWheel
+	retryTimer  *timingwheel.Timer
+	attempts    protocol.Int64
+	errMutex    sync.Mutex
+	lastErr     error
+
+	newConnectCallback protocol.OnNewConnectCallback
+	errorCallback      OnConnectErrorCallback
+
+	logger *log.FieldLogger
+}
+
+// New 创建 Connector, 只解析地址, 调用 Start 后才真正发起连接
+func New(option protocol.NetWorkAndAddressAndOption, loop *event_loop.EventLoop) (*Connector, error) {
+	domain, sa, err := resolveSockaddr(option.Network, option.Address)
+	if err != nil {
+		return nil, err
+	}
+	c := &Connector{
+		loop:   loop,
+		domain: domain,
+		sa:     sa,
+		state:  Disconnected,
+	}
+	c.SetLogger(defaultLogger)
+	return c, nil
+}
+
+// SetLogger 使用 Client 的 logger, 附加上对端地址和 loop 的字段
+func (this *Connector) SetLogger(logger *log.FieldLogger) {
+	this.logger = logger.With(log.Peer(sockaddrString(this.sa)), log.Loop(this.loop.SequenceID))
+}
+
+func (this *Connector) SetNewConnectCallback(newConnectCallback protocol.OnNewConnectCallback) {
+	this.newConnectCallback = newConnectCallback
+}
+
+func (this *Connector) SetErrorCallback(errorCallback OnConnectErrorCallback) {
+	this.errorCallback = errorCallback
+}
+
+// SetRetry 开启失败重试, 等待由时间轮调度
+func (this *Connector) SetRetry(backoff *protocol.Backoff, tw *timingwheel.TimingWheel) {
+	this.retry = backoff
+	this.timingWheel = tw
+}
+
+// Start 可以在任意协程调用, 连接动作在 loop 中执行
+func (this *Connector) Start() {
+	this.wantConnect.Set(true)
+	this.loop.RunInLoop(func() {
+		this.attempts.Swap(0)
+		this.startInLoop()
+	})
+}
+
+// Restart 连接断开后重新连接; 开启重试时先退避再连接
+func (this *Connector) Restart() {
+	this.wantConnect.Set(true)
+	this.loop.RunInLoop(func() {
+		this.state = Disconnected
+		this.attempts.Swap(0)
+		if this.retry != nil {
+			this.retryLater(this.retry.Next(1))
+			return
+		}
+		this.startInLoop()
+	})
+}
+
+// Stop 取消正在进行的连接; 已经交出去的 fd 不受影响
+func (this *Connector) Stop() {
+	this.wantConnect.Set(false)
+	this.loop.RunInLoop(this.stopInLoop)
+}
+
+// Sockaddr 对端地址
+func (this *Connector) Sockaddr() unix.Sockaddr {
+	return this.sa
+}
+
+// Attempts 本轮(Start/Restart 之后)已经发起的连接次数
+func (this *Connector) Attempts() int64 {
+	return this.attempts.Get()
+}
+
+// LastError 最近一次连接失败的原因
+func (this *Connector) LastError() error {
+	this.errMutex.Lock()
+	defer this.errMutex.Unlock()
+	return this.lastErr
+}
+
+func (this *Connector) startInLoop() {
+	if !this.wantConnect.Get() {
+		this.logger.Debug("connector stopped, do not connect")
+		return
+	}
+	if this.state != Disconnected {
+		return
+	}
+	this.connect()
+}
+
+func (this *Connector) stopInLoop() {
+	if this.retryTimer != nil {
+		this.retryTimer.Stop()
+		this.retryTimer = nil
+	}
+	if this.state == Connecting {
+		this.state = Disconnected
+		fd := this.removeAndResetEvent()
+		_ = unix.Close(fd)
+	}
+}
+
+func (this *Connector) connect() {
+	this.attempts.Add(1)
+
+	fd, err := unix.Socket(this.domain, unix.SOCK_STREAM, 0)
+	if err != nil {
+		this.fail(-1, err)
+		return
+	}
+	unix.CloseOnExec(fd)
+	if err = unix.SetNonblock(fd, true); err != nil {
+		this.fail(fd, err)
+		return
+	}
+
+	err = unix.Connect(fd, this.sa)
+	switch err {
+	case nil, unix.EINPROGRESS, unix.EINTR, unix.EISCONN:
+		this.connecting(fd)
+	default:
+		this.fail(fd, err)
+	}
+}
+
+func (this *Connector) connecting(fd int) {
+	this.state = Connecting
+	this.event = event_loop.NewEvent(this.loop, fd)
+	this.event.SetWriteFunc(this.handleWrite)
+	this.event.SetErrorFunc(this.handleError)
+	this.event.SetCloseFunc(this.handleError)
+
+	if err := this.event.Register(); err != nil {
+		this.event = nil
+		this.fail(fd, err)
+		return
+	}
+	// 连接完成(成功或失败)时 fd 变为可写
+	if err := this.event.EnableWriting(true); err != nil {
+		this.fail(this.removeAndResetEvent(), err)
+	}
+}
+
+func (this *Connector) handleWrite() {
+	if this.state != Connecting {
+		return
+	}
+	fd := this.removeAndResetEvent()
+
+	if err := socketError(fd); err != nil {
+		this.fail(fd, err)
+		return
+	}
+	if isSelfConnect(fd) {
+		this.fail(fd, ErrSelfConnect)
+		return
+	}
+
+	this.state = Connected
+	if !this.wantConnect.Get() {
+		_ = unix.Close(fd)
+		return
+	}
+	this.newConnectCallback(fd, this.sa)
+}
+
+func (this *Connector) handleError() {
+	if this.state != Connecting {
+		return
+	}
+	fd := this.removeAndResetEvent()
+	err := socketError(fd)
+	if err == nil {
+		err = unix.ECONNREFUSED
+	}
+	this.fail(fd, err)
+}
+
+func (this *Connector) fail(fd int, err error) {
+	this.state = Disconnected
+	if fd >= 0 {
+		_ = unix.Close(fd)
+	}
+	this.logger.Error("connect failed", log.Err(err))
+
+	this.errMutex.Lock()
+	this.lastErr = err
+	this.errMutex.Unlock()
+
+	if this.errorCallback != nil {
+		this.errorCallback(err)
+	}
+
+	if this.retry == nil || !this.wantConnect.Get() {
+		return
+	}
+	attempts := this.attempts.Get()
+	if this.retry.MaxAttempts > 0 && attempts >= this.retry.MaxAttempts {
+		this.logger.Error("give up connecting", log.F("attempts", attempts))
+		return
+	}
+	this.retryLater(this.retry.Next(attempts))
+}
+
+// retryLater 时间轮到期后回到 loop 中重新连接
+func (this *Connector) retryLater(delay time.Duration) {
+	this.logger.Info("retry connecting", log.F("delay", delay))
+	this.retryTimer = this.timingWheel.AfterFunc(delay, func() {
+		this.loop.RunInLoop(func() {
+			this.retryTimer = nil
+			this.startInLoop()
+		})
+	})
+}
+
+// removeAndResetEvent 从 loop 中摘除等待可写的 event, fd 仍然打开
+func (this *Connector) removeAndResetEvent() int {
+	fd := this.event.GetFd()
+	if err := this.event.DisableAll(); err != nil {
+		this.logger.Error("disable connecting event failed", log.Err(err))
+	}
+	if err := this.event.UnRegister(); err != nil {
+		this.logger.Error("unregister connecting event failed", log.Err(err))
+	}
+	this.event = nil
+	return fd
+}
+
+func socketError(fd int) error {
+	errno, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return unix.Errno(errno)
+	}
+	return nil
+}
+
+func isSelfConnect(fd int) bool {
+	local, err := unix.Getsockname(fd)
+	if err != nil {
+		return false
+	}
+	peer, err := unix.Getpeername(fd)
+	if err != nil {
+		return false
+	}
+	if _, ok := local.(*unix.SockaddrUnix); ok {
+		return false
+	}
+	return sockaddrString(local) == sockaddrString(peer)
+}
+
+func resolveSockaddr(network, address string) (int, unix.Sockaddr, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	case "unix":
+		return unix.AF_UNIX, &unix.SockaddrUnix{Name: address}, nil
+	default:
+		return 0, nil, protocol.ErrProtocolNotSupported
+	}
+	addr, err := net.ResolveTCPAddr(network, address)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ip := addr.IP
+	if ip == nil {
+		// 和 net.Dial 一样, 没有 host 时连接本机
+		if network == "tcp6" {
+			ip = net.IPv6loopback
+		} else {
+			ip = net.IPv4(127, 0, 0, 1)
+		}
+	}
+
+	if ip4 := ip.To4(); ip4 != nil && network != "tcp6" {
+		sa := &unix.SockaddrInet4{Port: addr.Port}
+		copy(sa.Addr[:], ip4)
+		return unix.AF_INET, sa, nil
+	}
+	sa := &unix.SockaddrInet6{Port: addr.Port}
+	copy(sa.Addr[:], ip.To16())
+	if addr.Zone != "" {
+		if ifi, err := net.InterfaceByName(addr.Zone); err == nil {
+			sa.ZoneId = uint32(ifi.Index)
+		}
+	}
+	return unix.AF_INET6, sa, nil
+}
+
+func sockaddrString(sa unix.Sockaddr) string {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return (&net.TCPAddr{IP: net.IP(sa.Addr[:]), Port: sa.Port}).String()
+	case *unix.SockaddrInet6:
+		return (&net.TCPAddr{IP: net.IP(sa.Addr[:]), Port: sa.Port}).String()
+	case *unix.SockaddrUnix:
+		return sa.Name
+	default:
+		return "(unknown)"
+	}
+}
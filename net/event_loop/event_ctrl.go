@@ -1,6 +1,8 @@
 package event_loop
 
 import (
+	"time"
+
 	"github.com/zput/zput_net_golang/net/log"
 	"github.com/zput/zput_net_golang/net/protocol"
 	"github.com/zput/zput_net_golang/net/multiplex"
@@ -12,6 +14,10 @@ type EventCtrl struct{
 	*/
 	eventPool map[int]*Event
 	multi *multiplex.Multiplex
+
+	// 开启指标时记录这一轮第一个事件开始处理的时间
+	recordHandleStart bool
+	handleStart time.Time
 }
 
 func NewEventCtrl()(*EventCtrl, error){
@@ -52,6 +58,9 @@ func (this *EventCtrl)waitAndRunHandle(PollTimeMs int){
 }
 
 func (this *EventCtrl) handlerEventWrap(fd int, eventType protocol.EventType) {
+	if this.recordHandleStart && this.handleStart.IsZero() {
+		this.handleStart = time.Now()
+	}
 	//this.eventHandling.Set(true)
 	if fd != -1 {
 		tempEvent := this.eventPool[fd]
@@ -67,6 +76,13 @@ func (this *EventCtrl) handlerEventWrap(fd int, eventType protocol.EventType) {
 	//l.eventHandling.Set(false)
 }
 
+// takeHandleStart 这一轮第一个事件开始处理的时间, 没有事件时为零值
+func (this *EventCtrl)takeHandleStart()time.Time{
+	start := this.handleStart
+	this.handleStart = time.Time{}
+	return start
+}
+
 func (this *EventCtrl)wake()error{
 	return this.multi.Wake()
 }
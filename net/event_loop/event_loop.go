@@ -2,8 +2,10 @@ package event_loop
 
 import (
 	"github.com/zput/zput_net_golang/net/log"
+	"github.com/zput/zput_net_golang/net/metrics"
 	"github.com/zput/zput_net_golang/net/protocol"
 	"sync"
+	"time"
 )
 
 type EventLoop struct{
@@ -16,6 +18,11 @@ type EventLoop struct{
 
 	running  protocol.Bool
 	waitDone chan struct{}
+
+	// 分配到这个 loop 上还没有关闭的连接数
+	connectCount protocol.Int64
+
+	metrics *metrics.LoopMetrics
 }
 
 func New(sequenceID int)(*EventLoop, error){
@@ -34,12 +41,25 @@ func New(sequenceID int)(*EventLoop, error){
 	return &loop, nil
 }
 
+// SetEdgeTriggered 切换为边沿触发, 需要在注册任何 Event 之前调用;
+// 边沿触发时 Event 的读写回调需要一直读写到 EAGAIN
+func (this *EventLoop) SetEdgeTriggered(edgeTriggered bool) {
+	this.eventCtrl.multi.SetEdgeTriggered(edgeTriggered)
+}
+
+func (this *EventLoop) IsEdgeTriggered() bool {
+	return this.eventCtrl.multi.IsEdgeTriggered()
+}
+
 func (this *EventLoop) Run(){
 	this.running.Set(true)
 
 	for {
 		this.eventCtrl.waitAndRunHandle(protocol.PollTimeMs)
 		this.runAllFunctionInLoop()
+		if this.metrics != nil {
+			this.observeIteration()
+		}
 
 		//在tcpaccept,tcpconnect关闭后再关闭。
 		if !this.running.Get() {
@@ -55,11 +75,21 @@ func (this *EventLoop)Stop()error{
 	}
 
 	this.running.Set(false)
+	// 唤醒阻塞在 wait 中的 loop, 不用等到超时
+	_ = this.wake()
 
 	<-this.waitDone //https://gfw.go101.org/article/channel.html
 	return this.eventCtrl.Stop()
 }
 
+// Close 释放 loop 的 epoll/kqueue fd; 用于创建之后没有运行过的 loop, 运行中的 loop 需要用 Stop
+func (this *EventLoop)Close()error{
+	if this.running.Get() {
+		return this.Stop()
+	}
+	return this.eventCtrl.Stop()
+}
+
 func (this *EventLoop)addEvent(event *Event)error{
 	return this.eventCtrl.addEvent(event)
 }
@@ -104,6 +134,39 @@ func(this *EventLoop)RunInLoop(fun protocol.AddFunToLoopWaitingRun){
 	return
 }
 
+// SetMetrics 开启 loop 的指标, 需要在 Run 之前调用
+func (this *EventLoop) SetMetrics(m *metrics.LoopMetrics) {
+	this.metrics = m
+	this.eventCtrl.recordHandleStart = m != nil
+}
+
+// observeIteration 记录这一轮处理就绪事件和 RunInLoop 任务的耗时, 不包括阻塞在 wait 中的时间
+func (this *EventLoop) observeIteration() {
+	start := this.eventCtrl.takeHandleStart()
+	if start.IsZero() {
+		// 等待超时, 没有任何事件
+		return
+	}
+	this.metrics.Observe(time.Since(start))
+}
+
+// PendingTasks 等待执行的 RunInLoop 任务数, 可以在任意协程调用
+func (this *EventLoop) PendingTasks() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return len(this.functions)
+}
+
+// AddConnectCount 连接分配到这个 loop 时加一, 关闭时减一; 可以在任意协程调用
+func (this *EventLoop)AddConnectCount(delta int64)int64{
+	return this.connectCount.Add(delta)
+}
+
+// ConnectCount 当前连接数
+func (this *EventLoop)ConnectCount()int64{
+	return this.connectCount.Get()
+}
+
 func (this *EventLoop)wake()error{
 	return this.eventCtrl.wake()
 }
@@ -0,0 +1,61 @@
+package log
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Field 结构化日志的一个 key/value
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F 任意的 key/value
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Fd 文件描述符
+func Fd(fd int) Field {
+	return Field{Key: "fd", Value: fd}
+}
+
+// Peer 对端地址
+func Peer(addr string) Field {
+	return Field{Key: "peer", Value: addr}
+}
+
+// Loop event loop 的 SequenceID, mainLoop 为 -1
+func Loop(id int) Field {
+	return Field{Key: "loop", Value: id}
+}
+
+// ConnID 连接 id
+func ConnID(id uint64) Field {
+	return Field{Key: "conn", Value: id}
+}
+
+// Err 错误
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+// formatFields 按 key=value 拼接, 值中有空格或引号时加引号
+func formatFields(fields []Field) string {
+	var b strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		v := fmt.Sprint(f.Value)
+		if strings.ContainsAny(v, " \"=") || v == "" {
+			v = strconv.Quote(v)
+		}
+		b.WriteString(v)
+	}
+	return b.String()
+}
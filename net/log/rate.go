@@ -0,0 +1,75 @@
+package log
+
+import (
+	"sync"
+	"time"
+)
+
+// maxRateWindows 最多单独限流的消息数, 超过后先清理过期的窗口, 仍然满时共用 overflow 窗口
+const maxRateWindows = 1024
+
+// rateLimiter 按消息限流, 消息是固定的字符串(变化的部分放在字段中), map 的大小不超过 maxRateWindows
+type rateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	burst    int
+	windows  map[string]*rateWindow
+	overflow rateWindow
+}
+
+type rateWindow struct {
+	start      time.Time
+	count      int
+	suppressed int
+}
+
+func newRateLimiter(interval time.Duration, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &rateLimiter{
+		interval: interval,
+		burst:    burst,
+		windows:  make(map[string]*rateWindow),
+	}
+}
+
+// allow 返回这一条是否输出, 以及上一个 interval 中被丢弃的条数
+func (this *rateLimiter) allow(msg string) (bool, int) {
+	now := time.Now()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	w := this.window(msg, now)
+	suppressed := 0
+	if now.Sub(w.start) >= this.interval {
+		suppressed = w.suppressed
+		w.start, w.count, w.suppressed = now, 0, 0
+	}
+	if w.count >= this.burst {
+		w.suppressed++
+		return false, 0
+	}
+	w.count++
+	return true, suppressed
+}
+
+// window 返回 msg 对应的窗口, map 满时清理已经过期并且没有丢弃记录的窗口
+func (this *rateLimiter) window(msg string, now time.Time) *rateWindow {
+	if w, ok := this.windows[msg]; ok {
+		return w
+	}
+	if len(this.windows) >= maxRateWindows {
+		for k, w := range this.windows {
+			if now.Sub(w.start) >= this.interval && w.suppressed == 0 {
+				delete(this.windows, k)
+			}
+		}
+	}
+	if len(this.windows) >= maxRateWindows {
+		return &this.overflow
+	}
+	w := &rateWindow{start: now}
+	this.windows[msg] = w
+	return w
+}
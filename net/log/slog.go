@@ -0,0 +1,43 @@
+//go:build go1.21
+// +build go1.21
+
+package log
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogSink struct {
+	logger *slog.Logger
+}
+
+// NewSlogSink 输出到 log/slog, 用法: log.NewFieldLogger(log.NewSlogSink(slog.Default()))
+func NewSlogSink(logger *slog.Logger) Sink {
+	return slogSink{logger: logger}
+}
+
+func slogLevel(l Level) slog.Level {
+	switch l {
+	case LevelFatal, LevelError:
+		return slog.LevelError
+	case LevelWarn:
+		return slog.LevelWarn
+	case LevelInfo:
+		return slog.LevelInfo
+	default:
+		return slog.LevelDebug
+	}
+}
+
+func (this slogSink) Enabled(l Level) bool {
+	return this.logger.Enabled(context.Background(), slogLevel(l))
+}
+
+func (this slogSink) Write(l Level, msg string, fields []Field) {
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		attrs[i] = slog.Any(f.Key, f.Value)
+	}
+	this.logger.LogAttrs(context.Background(), slogLevel(l), msg, attrs...)
+}
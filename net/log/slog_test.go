@@ -0,0 +1,26 @@
+//go:build go1.21
+// +build go1.21
+
+package log
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogSink(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewFieldLogger(NewSlogSink(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))))
+	logger.With(ConnID(3)).Warn("output buffer overflow, close", Fd(9))
+	logger.Debug("filtered")
+
+	out := buf.String()
+	if !strings.Contains(out, `level=WARN msg="output buffer overflow, close" conn=3 fd=9`) {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if strings.Contains(out, "filtered") {
+		t.Fatalf("debug should be filtered, get %q", out)
+	}
+}
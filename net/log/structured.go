@@ -0,0 +1,113 @@
+package log
+
+import (
+	"time"
+)
+
+// Sink 结构化日志的输出; 可以适配到 log/slog 等其他日志库
+type Sink interface {
+	Enabled(l Level) bool
+	Write(l Level, msg string, fields []Field)
+}
+
+// FieldLogger 带字段的分级日志, 每个 Server 一个实例, 通过 protocol.Logger 设置;
+// With 派生出的 logger 共享同一个 Sink 和限流状态, 可以在任意协程使用
+type FieldLogger struct {
+	sink    Sink
+	fields  []Field
+	limiter *rateLimiter
+}
+
+// DefaultRateInterval 内同一条 warn/error 日志默认最多输出 DefaultRateBurst 次
+const (
+	DefaultRateInterval = time.Second
+	DefaultRateBurst    = 10
+)
+
+// NewFieldLogger 输出到 sink, 使用默认的限流
+func NewFieldLogger(sink Sink) *FieldLogger {
+	return &FieldLogger{
+		sink:    sink,
+		limiter: newRateLimiter(DefaultRateInterval, DefaultRateBurst),
+	}
+}
+
+// Default 输出到全局的 Logger, 级别和前缀使用 SetLevel/SetPrefix 的设置
+func Default() *FieldLogger {
+	return NewFieldLogger(stdSink{})
+}
+
+// With 派生一个附加了 fields 的 logger
+func (this *FieldLogger) With(fields ...Field) *FieldLogger {
+	l := *this
+	l.fields = make([]Field, 0, len(this.fields)+len(fields))
+	l.fields = append(l.fields, this.fields...)
+	l.fields = append(l.fields, fields...)
+	return &l
+}
+
+// RateLimit 派生一个新的限流设置的 logger: 同一条 warn/error 消息每个 interval 内最多输出 burst 次,
+// 下一个 interval 第一次输出时带上 suppressed 字段说明丢弃的条数; interval <= 0 时不限流
+func (this *FieldLogger) RateLimit(interval time.Duration, burst int) *FieldLogger {
+	l := *this
+	l.limiter = nil
+	if interval > 0 {
+		l.limiter = newRateLimiter(interval, burst)
+	}
+	return &l
+}
+
+func (this *FieldLogger) Enabled(l Level) bool {
+	return this.sink.Enabled(l)
+}
+
+func (this *FieldLogger) Debug(msg string, fields ...Field) {
+	this.log(LevelDebug, msg, fields)
+}
+
+func (this *FieldLogger) Info(msg string, fields ...Field) {
+	this.log(LevelInfo, msg, fields)
+}
+
+func (this *FieldLogger) Warn(msg string, fields ...Field) {
+	this.log(LevelWarn, msg, fields)
+}
+
+func (this *FieldLogger) Error(msg string, fields ...Field) {
+	this.log(LevelError, msg, fields)
+}
+
+func (this *FieldLogger) log(l Level, msg string, fields []Field) {
+	if !this.sink.Enabled(l) {
+		return
+	}
+	all := make([]Field, 0, len(this.fields)+len(fields)+1)
+	all = append(all, this.fields...)
+	all = append(all, fields...)
+	// 只限制 warn/error, debug/info 由级别控制
+	if l <= LevelWarn && this.limiter != nil {
+		allow, suppressed := this.limiter.allow(msg)
+		if !allow {
+			return
+		}
+		if suppressed > 0 {
+			all = append(all, F("suppressed", suppressed))
+		}
+	}
+	this.sink.Write(l, msg, all)
+}
+
+// stdSink 通过全局的 Logger 输出 "msg key=value ..."
+type stdSink struct{}
+
+func (stdSink) Enabled(l Level) bool {
+	return l <= level
+}
+
+func (stdSink) Write(l Level, msg string, fields []Field) {
+	if len(fields) > 0 {
+		Logf(l, "%s %s", msg, formatFields(fields))
+		return
+	}
+	Logf(l, "%s", msg)
+}
@@ -0,0 +1,109 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type record struct {
+	level  Level
+	msg    string
+	fields []Field
+}
+
+type captureSink struct {
+	level   Level
+	records []record
+}
+
+func (this *captureSink) Enabled(l Level) bool {
+	return l <= this.level
+}
+
+func (this *captureSink) Write(l Level, msg string, fields []Field) {
+	this.records = append(this.records, record{level: l, msg: msg, fields: fields})
+}
+
+func TestFieldLoggerWith(t *testing.T) {
+	sink := &captureSink{level: LevelInfo}
+	logger := NewFieldLogger(sink)
+	conn := logger.With(ConnID(1), Fd(7))
+	conn.With(Peer("127.0.0.1:80")).Error("read failed", Err(errors.New("reset")))
+	conn.Info("closed")
+	conn.Debug("filtered by level")
+
+	if len(sink.records) != 2 {
+		t.Fatalf("expect 2 records, get %d", len(sink.records))
+	}
+	got := formatFields(sink.records[0].fields)
+	if expect := `conn=1 fd=7 peer=127.0.0.1:80 error=reset`; got != expect {
+		t.Fatalf("expect %q, get %q", expect, got)
+	}
+	if got := formatFields(sink.records[1].fields); got != "conn=1 fd=7" {
+		t.Fatalf("With should not share fields, get %q", got)
+	}
+	if got := formatFields([]Field{F("msg", "a b")}); got != `msg="a b"` {
+		t.Fatalf("expect quoted value, get %q", got)
+	}
+}
+
+func TestFieldLoggerRateLimit(t *testing.T) {
+	sink := &captureSink{level: LevelDebug}
+	logger := NewFieldLogger(sink).RateLimit(time.Millisecond*50, 2)
+
+	for i := 0; i < 5; i++ {
+		logger.With(Fd(i)).Error("read failed")
+		logger.Debug("not limited")
+	}
+	logger.Error("other message")
+	errorCount := 0
+	for _, r := range sink.records {
+		if r.level == LevelError {
+			errorCount++
+		}
+	}
+	// read failed 2 次 + other message 1 次
+	if errorCount != 3 {
+		t.Fatalf("expect 3 error records, get %d", errorCount)
+	}
+
+	time.Sleep(time.Millisecond * 60)
+	sink.records = nil
+	logger.Error("read failed")
+	if len(sink.records) != 1 {
+		t.Fatalf("expect 1 record, get %d", len(sink.records))
+	}
+	fields := sink.records[0].fields
+	if last := fields[len(fields)-1]; last.Key != "suppressed" || last.Value != 3 {
+		t.Fatalf("expect suppressed=3, get %v", fields)
+	}
+
+	sink.records = nil
+	unlimited := logger.RateLimit(0, 0)
+	for i := 0; i < 5; i++ {
+		unlimited.Error("read failed")
+	}
+	if len(sink.records) != 5 {
+		t.Fatalf("expect 5 records without rate limit, get %d", len(sink.records))
+	}
+}
+
+func TestRateLimiterWindowsBounded(t *testing.T) {
+	limiter := newRateLimiter(time.Millisecond*20, 1)
+	for i := 0; i < maxRateWindows*2; i++ {
+		limiter.allow(fmt.Sprintf("message %d", i))
+	}
+	if len(limiter.windows) > maxRateWindows {
+		t.Fatalf("expect at most %d windows, get %d", maxRateWindows, len(limiter.windows))
+	}
+	// 过期的窗口被清理后, 新消息重新单独限流
+	time.Sleep(time.Millisecond * 30)
+	if allow, _ := limiter.allow("fresh"); !allow {
+		t.Fatal("expect fresh message allowed")
+	}
+	if _, ok := limiter.windows["fresh"]; !ok {
+		t.Fatal("expect stale windows evicted")
+	}
+}
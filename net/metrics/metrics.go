@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Counter 只增不减的计数, 对应 Prometheus counter
+type Counter struct {
+	value int64
+}
+
+func (this *Counter) Inc() {
+	atomic.AddInt64(&this.value, 1)
+}
+
+func (this *Counter) Add(n int64) {
+	atomic.AddInt64(&this.value, n)
+}
+
+func (this *Counter) Value() int64 {
+	return atomic.LoadInt64(&this.value)
+}
+
+// Gauge 可增可减的当前值, 对应 Prometheus gauge
+type Gauge struct {
+	value int64
+}
+
+func (this *Gauge) Set(n int64) {
+	atomic.StoreInt64(&this.value, n)
+}
+
+func (this *Gauge) Add(n int64) {
+	atomic.AddInt64(&this.value, n)
+}
+
+func (this *Gauge) Value() int64 {
+	return atomic.LoadInt64(&this.value)
+}
+
+// DefaultLatencyBuckets loop 一轮处理耗时的分桶, 单位秒
+var DefaultLatencyBuckets = []float64{
+	0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1,
+}
+
+// Histogram 固定分桶的耗时分布, 对应 Prometheus histogram
+type Histogram struct {
+	// 原子访问的 int64 放在最前面, 保证 32 位平台上 8 字节对齐
+	count int64
+	sum   int64
+	// 每个桶的上限, 单位秒, 升序
+	buckets []float64
+	// bounds 和 buckets 对应, 单位纳秒, 避免 Observe 时做浮点转换
+	bounds []int64
+	// counts[i] 落在 (buckets[i-1], buckets[i]] 中的次数, 最后一个是 +Inf
+	counts []int64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	h := Histogram{
+		buckets: buckets,
+		bounds:  make([]int64, len(buckets)),
+		counts:  make([]int64, len(buckets)+1),
+	}
+	for i, bucket := range buckets {
+		h.bounds[i] = int64(bucket * float64(time.Second))
+	}
+	return &h
+}
+
+// Observe 记录一次耗时
+func (this *Histogram) Observe(d time.Duration) {
+	i := 0
+	for ; i < len(this.bounds) && int64(d) > this.bounds[i]; i++ {
+	}
+	atomic.AddInt64(&this.counts[i], 1)
+	atomic.AddInt64(&this.count, 1)
+	atomic.AddInt64(&this.sum, int64(d))
+}
+
+func (this *Histogram) Count() int64 {
+	return atomic.LoadInt64(&this.count)
+}
+
+// ConnectMetrics 一个 Server 所有连接共享的指标; 方法对 nil 安全, 没有开启指标时调用方不用判断
+type ConnectMetrics struct {
+	Accepted       Counter
+	Closed         Counter
+	BytesRead      Counter
+	BytesWritten   Counter
+	FramesDecoded  Counter
+	FramesEncoded  Counter
+	DecodeErrors   Counter
+	OutBufferBytes Gauge
+}
+
+// NewConnectMetrics 注册连接相关的指标, codec 作为帧相关指标的标签
+func NewConnectMetrics(registry *Registry, codec string) *ConnectMetrics {
+	var m ConnectMetrics
+	codecLabel := Labels{"codec": codec}
+	registry.Counter("connections_accepted_total", "Connections accepted.", nil, &m.Accepted)
+	registry.Counter("connections_closed_total", "Connections closed.", nil, &m.Closed)
+	registry.Counter("bytes_read_total", "Bytes read from sockets.", nil, &m.BytesRead)
+	registry.Counter("bytes_written_total", "Bytes written to sockets.", nil, &m.BytesWritten)
+	registry.Counter("frames_decoded_total", "Frames decoded by the codec.", codecLabel, &m.FramesDecoded)
+	registry.Counter("frames_encoded_total", "Frames encoded by the codec.", codecLabel, &m.FramesEncoded)
+	registry.Counter("decode_errors_total", "Codec decode errors.", codecLabel, &m.DecodeErrors)
+	registry.Gauge("out_buffer_bytes", "Bytes waiting in connection output buffers.", nil, &m.OutBufferBytes)
+	return &m
+}
+
+func (this *ConnectMetrics) Accept() {
+	if this != nil {
+		this.Accepted.Inc()
+	}
+}
+
+// Close 连接关闭, buffered 为丢弃的发送缓冲区大小
+func (this *ConnectMetrics) Close(buffered int) {
+	if this != nil {
+		this.Closed.Inc()
+		this.OutBufferBytes.Add(-int64(buffered))
+	}
+}
+
+func (this *ConnectMetrics) Read(n int) {
+	if this != nil {
+		this.BytesRead.Add(int64(n))
+	}
+}
+
+func (this *ConnectMetrics) Written(n int) {
+	if this != nil {
+		this.BytesWritten.Add(int64(n))
+	}
+}
+
+// Buffered 发送缓冲区增加 n 个字节, n 为负数表示写出
+func (this *ConnectMetrics) Buffered(n int) {
+	if this != nil {
+		this.OutBufferBytes.Add(int64(n))
+	}
+}
+
+func (this *ConnectMetrics) Decoded() {
+	if this != nil {
+		this.FramesDecoded.Inc()
+	}
+}
+
+func (this *ConnectMetrics) Encoded() {
+	if this != nil {
+		this.FramesEncoded.Inc()
+	}
+}
+
+func (this *ConnectMetrics) DecodeError() {
+	if this != nil {
+		this.DecodeErrors.Inc()
+	}
+}
+
+// LoopMetrics 一个 EventLoop 的指标
+type LoopMetrics struct {
+	IterationLatency *Histogram
+}
+
+// NewLoopMetrics 注册 loop 的指标, pendingTasks 在采集时调用, 返回等待执行的 RunInLoop 任务数
+func NewLoopMetrics(registry *Registry, loop int, pendingTasks func() int) *LoopMetrics {
+	labels := Labels{"loop": itoa(loop)}
+	m := LoopMetrics{
+		IterationLatency: registry.Histogram("loop_iteration_seconds",
+			"Time spent handling ready events and queued tasks per loop iteration.", labels, DefaultLatencyBuckets),
+	}
+	registry.GaugeFunc("loop_pending_tasks", "Tasks queued by RunInLoop and not yet run.", labels, func() float64 {
+		return float64(pendingTasks())
+	})
+	return &m
+}
+
+func (this *LoopMetrics) Observe(d time.Duration) {
+	if this != nil {
+		this.IterationLatency.Observe(d)
+	}
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	registry := NewRegistry("test")
+	m := NewConnectMetrics(registry, "*protocol.BuiltInFrameCodec")
+	m.Accept()
+	m.Read(10)
+	m.Buffered(6)
+	m.Buffered(-4)
+	m.DecodeError()
+
+	loop := NewLoopMetrics(registry, 0, func() int { return 3 })
+	loop.Observe(time.Microsecond * 80)
+	loop.Observe(time.Second)
+
+	var buf bytes.Buffer
+	if _, err := registry.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE test_connections_accepted_total counter\ntest_connections_accepted_total 1\n",
+		"test_bytes_read_total 10\n",
+		"test_out_buffer_bytes 2\n",
+		`test_decode_errors_total{codec="*protocol.BuiltInFrameCodec"} 1` + "\n",
+		`test_loop_pending_tasks{loop="0"} 3` + "\n",
+		`test_loop_iteration_seconds_bucket{loop="0",le="5e-05"} 0` + "\n",
+		`test_loop_iteration_seconds_bucket{loop="0",le="0.0001"} 1` + "\n",
+		`test_loop_iteration_seconds_bucket{loop="0",le="+Inf"} 2` + "\n",
+		`test_loop_iteration_seconds_count{loop="0"} 2` + "\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Fatalf("expect %q in:\n%s", line, out)
+		}
+	}
+	if strings.Count(out, "# TYPE test_loop_iteration_seconds ") != 1 {
+		t.Fatalf("expect TYPE written once:\n%s", out)
+	}
+}
+
+func TestMetricsNil(t *testing.T) {
+	var m *ConnectMetrics
+	m.Accept()
+	m.Read(1)
+	m.Close(1)
+	var loop *LoopMetrics
+	loop.Observe(time.Millisecond)
+}
+
+func TestMetricsNoAlloc(t *testing.T) {
+	registry := NewRegistry("")
+	m := NewConnectMetrics(registry, "codec")
+	loop := NewLoopMetrics(registry, 1, func() int { return 0 })
+
+	allocs := testing.AllocsPerRun(100, func() {
+		m.Read(128)
+		m.Decoded()
+		m.Encoded()
+		m.Written(128)
+		m.Buffered(64)
+		loop.Observe(time.Millisecond)
+	})
+	if allocs != 0 {
+		t.Fatalf("expect no allocation, get %v", allocs)
+	}
+}
@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Labels 指标的标签
+type Labels map[string]string
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// DefaultNamespace 指标名的默认前缀
+const DefaultNamespace = "zput"
+
+type metric struct {
+	name   string
+	help   string
+	typ    string
+	labels string
+	// 按标签排序好的 k="v" 列表, histogram 需要再拼上 le
+	labelPairs []string
+
+	value     func() float64
+	histogram *Histogram
+}
+
+// Registry 保存所有指标, 以 Prometheus 文本格式输出; 注册时加锁, 更新指标只有原子操作
+type Registry struct {
+	namespace string
+	mutex     sync.Mutex
+	metrics   []*metric
+}
+
+// NewRegistry namespace 为空时使用 DefaultNamespace; 同一个进程中有多个 Server 时用不同的 namespace 区分
+func NewRegistry(namespace string) *Registry {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	return &Registry{namespace: namespace}
+}
+
+// Counter 注册一个 counter, c 为 nil 时新建
+func (this *Registry) Counter(name, help string, labels Labels, c *Counter) *Counter {
+	if c == nil {
+		c = new(Counter)
+	}
+	this.register(name, help, typeCounter, labels, func() float64 {
+		return float64(c.Value())
+	}, nil)
+	return c
+}
+
+// CounterFunc 采集时调用 f 得到 counter 的值
+func (this *Registry) CounterFunc(name, help string, labels Labels, f func() float64) {
+	this.register(name, help, typeCounter, labels, f, nil)
+}
+
+// Gauge 注册一个 gauge, g 为 nil 时新建
+func (this *Registry) Gauge(name, help string, labels Labels, g *Gauge) *Gauge {
+	if g == nil {
+		g = new(Gauge)
+	}
+	this.register(name, help, typeGauge, labels, func() float64 {
+		return float64(g.Value())
+	}, nil)
+	return g
+}
+
+// GaugeFunc 采集时调用 f 得到 gauge 的值
+func (this *Registry) GaugeFunc(name, help string, labels Labels, f func() float64) {
+	this.register(name, help, typeGauge, labels, f, nil)
+}
+
+// Histogram 注册一个耗时分布, buckets 为升序的上限, 单位秒
+func (this *Registry) Histogram(name, help string, labels Labels, buckets []float64) *Histogram {
+	h := newHistogram(buckets)
+	this.register(name, help, typeHistogram, labels, nil, h)
+	return h
+}
+
+func (this *Registry) register(name, help, typ string, labels Labels, value func() float64, h *Histogram) {
+	m := metric{
+		name:       this.namespace + "_" + name,
+		help:       help,
+		typ:        typ,
+		labelPairs: formatLabelPairs(labels),
+		value:      value,
+		histogram:  h,
+	}
+	m.labels = joinLabels(m.labelPairs)
+
+	this.mutex.Lock()
+	this.metrics = append(this.metrics, &m)
+	this.mutex.Unlock()
+}
+
+// WriteTo 以 Prometheus 文本格式输出, 同名的指标写在一起, HELP/TYPE 只写一次
+func (this *Registry) WriteTo(w io.Writer) (int64, error) {
+	this.mutex.Lock()
+	metrics := make([]*metric, len(this.metrics))
+	copy(metrics, this.metrics)
+	this.mutex.Unlock()
+
+	// 稳定排序, 同名指标保持注册顺序
+	sort.SliceStable(metrics, func(i, j int) bool {
+		return metrics[i].name < metrics[j].name
+	})
+
+	bw := bufio.NewWriter(w)
+	var n int64
+	write := func(s string) {
+		written, _ := bw.WriteString(s)
+		n += int64(written)
+	}
+
+	for i, m := range metrics {
+		if i == 0 || metrics[i-1].name != m.name {
+			write("# HELP " + m.name + " " + m.help + "\n")
+			write("# TYPE " + m.name + " " + m.typ + "\n")
+		}
+		if m.histogram != nil {
+			writeHistogram(write, m)
+			continue
+		}
+		write(m.name + m.labels + " " + formatFloat(m.value()) + "\n")
+	}
+	return n, bw.Flush()
+}
+
+func writeHistogram(write func(string), m *metric) {
+	h := m.histogram
+	var cumulative int64
+	for i, bucket := range h.buckets {
+		cumulative += atomic.LoadInt64(&h.counts[i])
+		le := append(m.labelPairs[:len(m.labelPairs):len(m.labelPairs)], `le="`+formatFloat(bucket)+`"`)
+		write(m.name + "_bucket" + joinLabels(le) + " " + strconv.FormatInt(cumulative, 10) + "\n")
+	}
+	cumulative += atomic.LoadInt64(&h.counts[len(h.buckets)])
+	le := append(m.labelPairs[:len(m.labelPairs):len(m.labelPairs)], `le="+Inf"`)
+	write(m.name + "_bucket" + joinLabels(le) + " " + strconv.FormatInt(cumulative, 10) + "\n")
+	write(m.name + "_sum" + m.labels + " " + formatFloat(time.Duration(atomic.LoadInt64(&h.sum)).Seconds()) + "\n")
+	write(m.name + "_count" + m.labels + " " + strconv.FormatInt(h.Count(), 10) + "\n")
+}
+
+// Handler 输出指标的 http.Handler, 例如 http.Handle("/metrics", registry.Handler())
+func (this *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = this.WriteTo(w)
+	})
+}
+
+func formatLabelPairs(labels Labels) []string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+`="`+escapeLabelValue(v)+`"`)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+func joinLabels(pairs []string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func itoa(i int) string {
+	return strconv.Itoa(i)
+}
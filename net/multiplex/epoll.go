@@ -11,6 +11,7 @@ import (
 const readEvent = unix.EPOLLIN | unix.EPOLLPRI
 const writeEvent = unix.EPOLLOUT
 const errEvent = unix.EPOLLERR
+const edgeTriggeredEvent = unix.EPOLLET
 const nonEvent = 0
 
 
@@ -19,6 +20,8 @@ type Multiplex struct {
 	fd       int // epoll fd
 	wakeEventFd  int // 用户唤醒的作用file describe
 	waitEvents []unix.EpollEvent
+	// 边沿触发, 需要在添加任何 fd 之前设置
+	edgeTriggered bool
 }
 
 // 创建epoll对象
@@ -93,9 +96,21 @@ func(this *Multiplex)epollCtrl(op int, fd int, eventType protocol.EventType)erro
 		Events : GetEpollEventsFromIOEvent(eventType),
 		Fd: int32(fd),
 	}
+	if this.edgeTriggered && epollEvent.Events != nonEvent {
+		epollEvent.Events |= edgeTriggeredEvent
+	}
 	return unix.EpollCtl(this.fd, op, fd, &epollEvent)
 }
 
+// SetEdgeTriggered 之后添加、修改的 fd 使用 EPOLLET
+func(this *Multiplex) SetEdgeTriggered(edgeTriggered bool){
+	this.edgeTriggered = edgeTriggered
+}
+
+func(this *Multiplex) IsEdgeTriggered() bool{
+	return this.edgeTriggered
+}
+
 func(this *Multiplex) AddEvent(fd int, eventState protocol.EventType,  oldEventState protocol.EventType)error{
 	log.Debugf("AddEvent; ioEvent; fd:%v, eventType:%v", fd, eventState)
 	if err := this.epollCtrl(unix.EPOLL_CTL_ADD, fd, eventState); err != nil{
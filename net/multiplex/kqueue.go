@@ -14,6 +14,8 @@ type Multiplex struct {
 	waitEvents []unix.Kevent_t
 	//sockets    sync.Map // [fd]protocol.EventType
 	changes []unix.Kevent_t
+	// 边沿触发(EV_CLEAR), 需要在添加任何 fd 之前设置
+	edgeTriggered bool
 }
 
 func New() (*Multiplex, error) {
@@ -43,6 +45,15 @@ func (this *Multiplex) Close() (err error) {
 	return
 }
 
+// SetEdgeTriggered 之后添加的 filter 带上 EV_CLEAR
+func (this *Multiplex) SetEdgeTriggered(edgeTriggered bool) {
+	this.edgeTriggered = edgeTriggered
+}
+
+func (this *Multiplex) IsEdgeTriggered() bool {
+	return this.edgeTriggered
+}
+
 func (this *Multiplex) AddEvent(fd int, eventState protocol.EventType,  oldEventState protocol.EventType) error {
 	log.Debugf("AddEvent; ioEvent; fd:%v, eventType:%v, oldEventType:%v", fd, eventState, oldEventState)
 	kEvents := this.kEvents(protocol.EventNone, eventState, fd)
@@ -66,9 +77,14 @@ func (this *Multiplex) ModifyEvent(fd int, eventState protocol.EventType,  oldEv
 }
 
 func (this *Multiplex) kEvents(old protocol.EventType, new protocol.EventType, fd int) (ret []unix.Kevent_t) {
+	var add = unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_ADD}
+	if this.edgeTriggered {
+		add.Flags |= unix.EV_CLEAR
+	}
 	if new&protocol.EventRead != 0 {
 		if old&protocol.EventRead == 0 {
-			ret = append(ret, unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_READ})
+			add.Filter = unix.EVFILT_READ
+			ret = append(ret, add)
 		}
 	} else {
 		if old&protocol.EventRead != 0 {
@@ -78,7 +94,8 @@ func (this *Multiplex) kEvents(old protocol.EventType, new protocol.EventType, f
 
 	if new&protocol.EventWrite != 0 {
 		if old&protocol.EventWrite == 0 {
-			ret = append(ret, unix.Kevent_t{Ident: uint64(fd), Flags: unix.EV_ADD, Filter: unix.EVFILT_WRITE})
+			add.Filter = unix.EVFILT_WRITE
+			ret = append(ret, add)
 		}
 	} else {
 		if old&protocol.EventWrite != 0 {
@@ -0,0 +1,65 @@
+package protocol
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff 指数退避重连配置
+type Backoff struct {
+	// Initial 第一次重试前的等待时间
+	Initial time.Duration
+	// Max 等待时间上限, <= 0 时只受 time.Duration 的范围限制
+	Max time.Duration
+	// Multiplier 每次失败后等待时间的倍数
+	Multiplier float64
+	// Jitter 随机抖动比例, 0.2 表示在 [0.8, 1.2] 倍之间浮动, 取值 [0, 1], 超过 1 按 1 处理
+	Jitter float64
+	// MaxAttempts 最多尝试次数, 0 表示不限制
+	MaxAttempts int64
+}
+
+// DefaultBackoff 默认的退避配置
+var DefaultBackoff = Backoff{
+	Initial:    500 * time.Millisecond,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Next 第 attempt(从 1 开始) 次失败后需要等待的时间
+func (b *Backoff) Next(attempt int64) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	// 没有设置 Max 时也不能超过 time.Duration 的范围, 溢出后等待时间变为负数, 会立即重试
+	max := float64(math.MaxInt64)
+	if b.Max > 0 {
+		max = float64(b.Max)
+	}
+	delay := float64(b.Initial)
+	for i := int64(1); i < attempt && delay < max; i++ {
+		delay *= multiplier
+	}
+	if delay > max {
+		delay = max
+	}
+	if jitter := b.Jitter; jitter > 0 {
+		// 超过 1 时等待时间可能为负数
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay += delay * jitter * (rand.Float64()*2 - 1)
+	}
+	// float64(math.MaxInt64) 是 2^63, 转换时会溢出
+	if delay >= float64(math.MaxInt64) {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(delay)
+}
@@ -0,0 +1,65 @@
+package protocol
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBackoffNext(t *testing.T) {
+	b := Backoff{
+		Initial:    100 * time.Millisecond,
+		Max:        time.Second,
+		Multiplier: 2,
+	}
+
+	var loopTest = []struct {
+		attempt int64
+		expect  time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{100, time.Second},
+	}
+	for _, tt := range loopTest {
+		if got := b.Next(tt.attempt); got != tt.expect {
+			t.Fatalf("attempt %d; expect %v, get %v", tt.attempt, tt.expect, got)
+		}
+	}
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		got := b.Next(2)
+		if got < 100*time.Millisecond || got > 300*time.Millisecond {
+			t.Fatalf("jitter out of range; get %v", got)
+		}
+	}
+
+	b.Jitter = 3
+	for i := 0; i < 100; i++ {
+		got := b.Next(2)
+		if got < 0 || got > 400*time.Millisecond {
+			t.Fatalf("jitter out of range; get %v", got)
+		}
+	}
+
+	// 没有上限时不能溢出成负数
+	unbounded := Backoff{Initial: 500 * time.Millisecond, Multiplier: 2}
+	if got := unbounded.Next(3); got != 2*time.Second {
+		t.Fatalf("expect 2s, get %v", got)
+	}
+	for _, attempt := range []int64{40, 64, 1000} {
+		if got := unbounded.Next(attempt); got != time.Duration(math.MaxInt64) {
+			t.Fatalf("attempt %d; expect max duration, get %v", attempt, got)
+		}
+	}
+	unbounded.Jitter = 1
+	for i := 0; i < 100; i++ {
+		if got := unbounded.Next(40); got < 0 {
+			t.Fatalf("expect non-negative delay, get %v", got)
+		}
+	}
+}
@@ -5,18 +5,33 @@
 package protocol
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 )
 
+// Conn codec 看到的接收缓冲区, 数据可能分成几段(环形缓冲区的两段和这次读到的数据)
 type Conn interface {
+	// Read 返回全部数据, 分段时需要拷贝; 逐帧解码时用 IndexByte/PeekAt/Frame
 	Read() []byte
 	ResetBuffer()
 	ReadN(n int) (size int, buf []byte)
 	ShiftN(n int) (size int)
 	BufferLength() int
+
+	// PeekAt 从 offset 开始拷贝 len(p) 个字节到 p, 返回拷贝的字节数, 用来读取帧头
+	PeekAt(p []byte, offset int) int
+	// IndexByte 从 offset 开始查找 b, 返回相对于开头的位置, 没有时返回 -1
+	IndexByte(offset int, b byte) int
+	// Frame 返回 [offset, offset+n) 的数据, 不够时返回 nil; 数据连续时不拷贝,
+	// 跨越分段时才拷贝; 只在这一次 MessageCallback 中有效
+	Frame(offset, n int) []byte
+}
+
+// CodecContext 有状态的 codec 用来在连接上保存自己的状态, *connect.Connect 实现了这个接口
+type CodecContext interface {
+	CodecContext() interface{}
+	SetCodecContext(ctx interface{})
 }
 
 
@@ -29,9 +44,16 @@ type (
 		// Encode encodes frames upon server responses into TCP stream.
 		Encode(c Conn, buf []byte) ([]byte, error)
 		// Decode decodes frames from TCP stream via specific implementation.
+		// The returned frame may point into the connection's buffer and is only valid until the next Decode.
 		Decode(c Conn) ([]byte, error)
 	}
 
+	// FramesEncoder is implemented by codecs that can emit a frame as several buffers, such as a header and
+	// the payload; the connection writes them with one writev instead of concatenating them.
+	FramesEncoder interface {
+		EncodeFrames(c Conn, buf []byte) ([][]byte, error)
+	}
+
 	// BuiltInFrameCodec is the built-in codec which will be assigned to gnet server when customized codec is not set up.
 	BuiltInFrameCodec struct {
 	}
@@ -66,11 +88,12 @@ func (cc *BuiltInFrameCodec) Encode(c Conn, buf []byte) ([]byte, error) {
 
 // Decode ...
 func (cc *BuiltInFrameCodec) Decode(c Conn) ([]byte, error) {
-	buf := c.Read()
-	c.ResetBuffer()
-	if len(buf) == 0 {
-		buf = nil
+	n := c.BufferLength()
+	if n == 0 {
+		return nil, nil
 	}
+	buf := c.Frame(0, n)
+	c.ShiftN(n)
 	return buf, nil
 }
 
@@ -81,13 +104,13 @@ func (cc *LineBasedFrameCodec) Encode(c Conn, buf []byte) ([]byte, error) {
 
 // Decode ...
 func (cc *LineBasedFrameCodec) Decode(c Conn) ([]byte, error) {
-	buf := c.Read()
-	idx := bytes.IndexByte(buf, CRLFByte)
+	idx := c.IndexByte(0, CRLFByte)
 	if idx == -1 {
-		return nil, ErrCRLFNotFound
+		return nil, ErrIncompleteFrame
 	}
+	buf := c.Frame(0, idx)
 	c.ShiftN(idx + 1)
-	return buf[:idx], nil
+	return buf, nil
 }
 
 // NewDelimiterBasedFrameCodec instantiates and returns a codec with a specific delimiter.
@@ -102,13 +125,13 @@ func (cc *DelimiterBasedFrameCodec) Encode(c Conn, buf []byte) ([]byte, error) {
 
 // Decode ...
 func (cc *DelimiterBasedFrameCodec) Decode(c Conn) ([]byte, error) {
-	buf := c.Read()
-	idx := bytes.IndexByte(buf, cc.delimiter)
+	idx := c.IndexByte(0, cc.delimiter)
 	if idx == -1 {
-		return nil, errDelimiterNotFound
+		return nil, ErrIncompleteFrame
 	}
+	buf := c.Frame(0, idx)
 	c.ShiftN(idx + 1)
-	return buf[:idx], nil
+	return buf, nil
 }
 
 // NewFixedLengthFrameCodec instantiates and returns a codec with fixed length.
@@ -126,11 +149,11 @@ func (cc *FixedLengthFrameCodec) Encode(c Conn, buf []byte) ([]byte, error) {
 
 // Decode ...
 func (cc *FixedLengthFrameCodec) Decode(c Conn) ([]byte, error) {
-	size, buf := c.ReadN(cc.frameLength)
-	if size == 0 {
-		return nil, errUnexpectedEOF
+	if c.BufferLength() < cc.frameLength {
+		return nil, ErrIncompleteFrame
 	}
-	c.ShiftN(size)
+	buf := c.Frame(0, cc.frameLength)
+	c.ShiftN(cc.frameLength)
 	return buf, nil
 }
 
@@ -166,11 +189,46 @@ type DecoderConfig struct {
 	LengthAdjustment int
 	// InitialBytesToStrip is the number of first bytes to strip out from the decoded frame
 	InitialBytesToStrip int
+	// MaxFrameLength is the maximum length of a whole frame, including the header and the length field.
+	// Zero means no limit. A frame exceeding it makes Decode return an error wrapping ErrTooLongFrame.
+	MaxFrameLength uint64
+	// DiscardTooLongFrame discards the bytes of a too long frame as they arrive, like netty, and then goes on
+	// with the next frame; the handler should return DecodeErrorSkip from OnDecodeError.
+	// Without it a too long frame is an error at once and the connection is closed by default.
+	// It needs the Conn to implement CodecContext.
+	DiscardTooLongFrame bool
+	// FailFast reports a too long frame as soon as its length field is read; otherwise in discard mode
+	// the error is reported after the whole frame has been discarded.
+	FailFast bool
+}
+
+// lengthFieldDiscardState 丢弃过长帧时保存在连接上的状态
+type lengthFieldDiscardState struct {
+	discarding     bool
+	frameLength    uint64
+	bytesToDiscard uint64
 }
 
 // Encode ...
-func (cc *LengthFieldBasedFrameCodec) Encode(c Conn, buf []byte) (out []byte, err error) {
-	length := len(buf) + cc.encoderConfig.LengthAdjustment
+func (cc *LengthFieldBasedFrameCodec) Encode(c Conn, buf []byte) ([]byte, error) {
+	out, err := cc.encodeHeader(len(buf))
+	if err != nil {
+		return nil, err
+	}
+	return append(out, buf...), nil
+}
+
+// EncodeFrames implements FramesEncoder, the length field and the payload are written with one writev.
+func (cc *LengthFieldBasedFrameCodec) EncodeFrames(c Conn, buf []byte) ([][]byte, error) {
+	header, err := cc.encodeHeader(len(buf))
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{header, buf}, nil
+}
+
+func (cc *LengthFieldBasedFrameCodec) encodeHeader(payloadLength int) (out []byte, err error) {
+	length := payloadLength + cc.encoderConfig.LengthAdjustment
 	if cc.encoderConfig.LengthIncludesLengthFieldLength {
 		length += cc.encoderConfig.LengthFieldLength
 	}
@@ -205,8 +263,6 @@ func (cc *LengthFieldBasedFrameCodec) Encode(c Conn, buf []byte) (out []byte, er
 	default:
 		return nil, errUnsupportedLength
 	}
-
-	out = append(out, buf...)
 	return
 }
 
@@ -225,73 +281,138 @@ func (in *innerBuffer) readN(n int) (buf []byte, err error) {
 
 // Decode ...
 func (cc *LengthFieldBasedFrameCodec) Decode(c Conn) ([]byte, error) {
-	var (
-		in     innerBuffer
-		header []byte
-		err    error
-	)
-	in = c.Read()
-	if cc.decoderConfig.LengthFieldOffset > 0 { //discard header(offset)
-		header, err = in.readN(cc.decoderConfig.LengthFieldOffset)
-		if err != nil {
-			return nil, errUnexpectedEOF
-		}
+	state := cc.discardState(c)
+	if state != nil && state.discarding {
+		return cc.discard(c, state)
 	}
 
-	lenBuf, frameLength, err := cc.getUnadjustedFrameLength(&in)
+	// 先只读长度字段, 整帧到齐之后再取出, 不再每次拷贝整个缓冲区
+	offset := cc.decoderConfig.LengthFieldOffset
+	lengthFieldLength := cc.decoderConfig.LengthFieldLength
+	if lengthFieldLength <= 0 || lengthFieldLength > 8 {
+		return nil, errUnsupportedLength
+	}
+	// 长度字段跨越分段时 Frame 会拷贝, 解析完长度之后才取帧, 不会互相覆盖
+	lenBuf := c.Frame(offset, lengthFieldLength)
+	if lenBuf == nil {
+		return nil, ErrIncompleteFrame
+	}
+	frameLength, err := cc.getUnadjustedFrameLength(lenBuf)
 	if err != nil {
 		return nil, err
 	}
 
+	headerLength := offset + lengthFieldLength
+	if max := cc.decoderConfig.MaxFrameLength; max > 0 {
+		// 先于转换成 int 检查, 避免 8 字节的长度溢出
+		fullLength := uint64(headerLength) + frameLength
+		if adjustment := cc.decoderConfig.LengthAdjustment; adjustment >= 0 {
+			fullLength += uint64(adjustment)
+		} else if uint64(-adjustment) <= fullLength {
+			fullLength -= uint64(-adjustment)
+		} else {
+			// 调整后长度为负, 交给下面返回 errTooLessLength
+			fullLength = 0
+		}
+		if frameLength > max || fullLength > max {
+			return cc.tooLongFrame(c, state, fullLength)
+		}
+	}
+
 	// real message length
 	msgLength := int(frameLength) + cc.decoderConfig.LengthAdjustment
-	msg, err := in.readN(msgLength)
-	if err != nil {
-		return nil, errUnexpectedEOF
+	if msgLength < 0 {
+		return nil, errTooLessLength
+	}
+	fullLength := headerLength + msgLength
+	if fullLength > c.BufferLength() {
+		return nil, ErrIncompleteFrame
+	}
+	strip := cc.decoderConfig.InitialBytesToStrip
+	if strip > fullLength {
+		return nil, errTooLessLength
 	}
 
-	fullMessage := make([]byte, len(header)+len(lenBuf)+msgLength)
-	copy(fullMessage, header)
-	copy(fullMessage[len(header):], lenBuf)
-	copy(fullMessage[len(header)+len(lenBuf):], msg)
-	c.ShiftN(len(fullMessage))
-	return fullMessage[cc.decoderConfig.InitialBytesToStrip:], nil
+	frame := c.Frame(strip, fullLength-strip)
+	c.ShiftN(fullLength)
+	return frame, nil
+}
+
+func (cc *LengthFieldBasedFrameCodec) discardState(c Conn) *lengthFieldDiscardState {
+	if !cc.decoderConfig.DiscardTooLongFrame {
+		return nil
+	}
+	ctx, ok := c.(CodecContext)
+	if !ok {
+		return nil
+	}
+	state, ok := ctx.CodecContext().(*lengthFieldDiscardState)
+	if !ok {
+		state = new(lengthFieldDiscardState)
+		ctx.SetCodecContext(state)
+	}
+	return state
+}
+
+func (cc *LengthFieldBasedFrameCodec) tooLongFrameError(frameLength uint64) error {
+	return fmt.Errorf("%w: %d exceeds %d", ErrTooLongFrame, frameLength, cc.decoderConfig.MaxFrameLength)
+}
+
+// tooLongFrame 不丢弃时直接返回错误, 数据留在缓冲区中; 丢弃时进入丢弃模式, 每次返回错误前至少丢弃了一个字节
+func (cc *LengthFieldBasedFrameCodec) tooLongFrame(c Conn, state *lengthFieldDiscardState, frameLength uint64) ([]byte, error) {
+	if state == nil {
+		return nil, cc.tooLongFrameError(frameLength)
+	}
+	state.discarding = true
+	state.frameLength = frameLength
+	state.bytesToDiscard = frameLength
+	if !cc.decoderConfig.FailFast {
+		return cc.discard(c, state)
+	}
+	cc.discardBuffered(c, state)
+	return nil, cc.tooLongFrameError(frameLength)
 }
 
-func (cc *LengthFieldBasedFrameCodec) getUnadjustedFrameLength(in *innerBuffer) ([]byte, uint64, error) {
+// discard 丢弃过长帧剩余的数据, 丢弃完之后继续解码下一帧
+func (cc *LengthFieldBasedFrameCodec) discard(c Conn, state *lengthFieldDiscardState) ([]byte, error) {
+	cc.discardBuffered(c, state)
+	if state.discarding {
+		return nil, ErrIncompleteFrame
+	}
+	if !cc.decoderConfig.FailFast {
+		return nil, cc.tooLongFrameError(state.frameLength)
+	}
+	return cc.Decode(c)
+}
+
+func (cc *LengthFieldBasedFrameCodec) discardBuffered(c Conn, state *lengthFieldDiscardState) {
+	n := uint64(c.BufferLength())
+	if n > state.bytesToDiscard {
+		n = state.bytesToDiscard
+	}
+	if n > 0 {
+		c.ShiftN(int(n))
+	}
+	state.bytesToDiscard -= n
+	if state.bytesToDiscard == 0 {
+		state.discarding = false
+	}
+}
+
+func (cc *LengthFieldBasedFrameCodec) getUnadjustedFrameLength(lenBuf []byte) (uint64, error) {
 	switch cc.decoderConfig.LengthFieldLength {
 	case 1:
-		b, err := in.readN(1)
-		if err != nil {
-			return nil, 0, errUnexpectedEOF
-		}
-		return b, uint64(b[0]), nil
+		return uint64(lenBuf[0]), nil
 	case 2:
-		lenBuf, err := in.readN(2)
-		if err != nil {
-			return nil, 0, errUnexpectedEOF
-		}
-		return lenBuf, uint64(cc.decoderConfig.ByteOrder.Uint16(lenBuf)), nil
+		return uint64(cc.decoderConfig.ByteOrder.Uint16(lenBuf)), nil
 	case 3:
-		lenBuf, err := in.readN(3)
-		if err != nil {
-			return nil, 0, errUnexpectedEOF
-		}
-		return lenBuf, readUint24(cc.decoderConfig.ByteOrder, lenBuf), nil
+		return readUint24(cc.decoderConfig.ByteOrder, lenBuf), nil
 	case 4:
-		lenBuf, err := in.readN(4)
-		if err != nil {
-			return nil, 0, errUnexpectedEOF
-		}
-		return lenBuf, uint64(cc.decoderConfig.ByteOrder.Uint32(lenBuf)), nil
+		return uint64(cc.decoderConfig.ByteOrder.Uint32(lenBuf)), nil
 	case 8:
-		lenBuf, err := in.readN(8)
-		if err != nil {
-			return nil, 0, errUnexpectedEOF
-		}
-		return lenBuf, cc.decoderConfig.ByteOrder.Uint64(lenBuf), nil
+		return cc.decoderConfig.ByteOrder.Uint64(lenBuf), nil
 	default:
-		return nil, 0, errUnsupportedLength
+		return 0, errUnsupportedLength
 	}
 }
 
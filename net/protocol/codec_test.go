@@ -1,7 +1,9 @@
 package protocol
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"math/rand"
 	"testing"
 )
@@ -232,3 +234,140 @@ func TestInnerBufferReadN(t *testing.T) {
 		t.Fatal("wrong length of leftover bytes")
 	}
 }
+
+// testConn 用一段内存实现 Conn 和 CodecContext
+type testConn struct {
+	buf []byte
+	ctx interface{}
+}
+
+func (c *testConn) Read() []byte              { return c.buf }
+func (c *testConn) ResetBuffer()              { c.buf = c.buf[:0] }
+func (c *testConn) BufferLength() int         { return len(c.buf) }
+func (c *testConn) CodecContext() interface{} { return c.ctx }
+func (c *testConn) SetCodecContext(ctx interface{}) {
+	c.ctx = ctx
+}
+
+func (c *testConn) ReadN(n int) (int, []byte) {
+	if n > len(c.buf) {
+		return 0, nil
+	}
+	return n, c.buf[:n]
+}
+
+func (c *testConn) ShiftN(n int) int {
+	c.buf = c.buf[n:]
+	return n
+}
+
+func (c *testConn) PeekAt(p []byte, offset int) int {
+	if offset >= len(c.buf) {
+		return 0
+	}
+	return copy(p, c.buf[offset:])
+}
+
+func (c *testConn) IndexByte(offset int, b byte) int {
+	if idx := bytes.IndexByte(c.buf[offset:], b); idx >= 0 {
+		return offset + idx
+	}
+	return -1
+}
+
+func (c *testConn) Frame(offset, n int) []byte {
+	if offset+n > len(c.buf) {
+		return nil
+	}
+	return c.buf[offset : offset+n]
+}
+
+func newMaxFrameCodec(discard, failFast bool) *LengthFieldBasedFrameCodec {
+	return NewLengthFieldBasedFrameCodec(EncoderConfig{
+		ByteOrder:         binary.BigEndian,
+		LengthFieldLength: 8,
+	}, DecoderConfig{
+		ByteOrder:           binary.BigEndian,
+		LengthFieldLength:   8,
+		InitialBytesToStrip: 8,
+		MaxFrameLength:      16,
+		DiscardTooLongFrame: discard,
+		FailFast:            failFast,
+	})
+}
+
+func encodeFrames(t *testing.T, codec ICodec, frames ...string) []byte {
+	var out []byte
+	for _, f := range frames {
+		data, err := codec.Encode(nil, []byte(f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, data...)
+	}
+	return out
+}
+
+func TestLengthFieldBasedFrameCodecMaxFrameLength(t *testing.T) {
+	codec := newMaxFrameCodec(false, false)
+	c := &testConn{buf: encodeFrames(t, codec, "12345678")}
+	if frame, err := codec.Decode(c); err != nil || string(frame) != "12345678" {
+		t.Fatalf("expect frame within limit, get %q %v", frame, err)
+	}
+
+	// 只收到头部就拒绝, 即使长度字段声明了很大的值
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint64(header, 1<<62)
+	c = &testConn{buf: header}
+	if _, err := codec.Decode(c); !errors.Is(err, ErrTooLongFrame) {
+		t.Fatalf("expect ErrTooLongFrame, get %v", err)
+	}
+	if c.BufferLength() != 8 {
+		t.Fatalf("expect data kept without discard, get %d bytes", c.BufferLength())
+	}
+}
+
+func TestLengthFieldBasedFrameCodecDiscard(t *testing.T) {
+	for _, failFast := range []bool{false, true} {
+		codec := newMaxFrameCodec(true, failFast)
+		data := encodeFrames(t, codec, "this frame is too long", "ok")
+		// 过长的帧分两次到达
+		c := &testConn{buf: append([]byte(nil), data[:12]...)}
+
+		_, err := codec.Decode(c)
+		if failFast != errors.Is(err, ErrTooLongFrame) {
+			t.Fatalf("failFast %v: unexpected error %v at header", failFast, err)
+		}
+		if !failFast && !IsIncompleteFrame(err) {
+			t.Fatalf("expect incomplete while discarding, get %v", err)
+		}
+		if c.BufferLength() != 0 {
+			t.Fatalf("expect received bytes discarded, get %d", c.BufferLength())
+		}
+
+		c.buf = append(c.buf, data[12:]...)
+		frame, err := codec.Decode(c)
+		if !failFast {
+			if !errors.Is(err, ErrTooLongFrame) {
+				t.Fatalf("expect ErrTooLongFrame after discard, get %v", err)
+			}
+			frame, err = codec.Decode(c)
+		}
+		if err != nil || string(frame) != "ok" {
+			t.Fatalf("failFast %v: expect next frame ok, get %q %v", failFast, frame, err)
+		}
+	}
+}
+
+func TestLengthFieldBasedFrameCodecEncodeFrames(t *testing.T) {
+	codec := newMaxFrameCodec(false, false)
+	payload := []byte("payload")
+	frames, err := codec.EncodeFrames(nil, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := codec.Encode(nil, payload)
+	if len(frames) != 2 || &frames[1][0] != &payload[0] || !bytes.Equal(append(frames[0], frames[1]...), out) {
+		t.Fatalf("expect header and payload without copy, get %q", frames)
+	}
+}
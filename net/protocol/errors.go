@@ -10,14 +10,28 @@ var (
 	errServerShutdown = errors.New("server is going to be shutdown")
 	// errInvalidFixedLength occurs when the output data have invalid fixed length.
 	errInvalidFixedLength = errors.New("invalid fixed length of bytes")
-	// errUnexpectedEOF occurs when no enough data to read by codec.
-	errUnexpectedEOF = errors.New("there is no enough data")
-	// errDelimiterNotFound occurs when no such a delimiter is in input data.
-	errDelimiterNotFound = errors.New("there is no such a delimiter")
+	// ErrIncompleteFrame occurs when there is not enough data to decode a whole frame yet.
+	ErrIncompleteFrame = errors.New("incomplete frame")
 	// ErrCRLFNotFound occurs when a CRLF is not found by codec.
-	ErrCRLFNotFound = errors.New("there is no CRLF")
+	//
+	// Deprecated: codecs return ErrIncompleteFrame, use IsIncompleteFrame instead.
+	ErrCRLFNotFound = ErrIncompleteFrame
 	// errUnsupportedLength occurs when unsupported lengthFieldLength is from input data.
 	errUnsupportedLength = errors.New("unsupported lengthFieldLength. (expected: 1, 2, 3, 4, or 8)")
 	// errTooLessLength occurs when adjusted frame length is less than zero.
 	errTooLessLength = errors.New("adjusted frame length is less than zero")
+	// ErrTooLongFrame occurs when a frame exceeds DecoderConfig.MaxFrameLength.
+	ErrTooLongFrame = errors.New("frame is too long")
+	// ErrTooManyConnections occurs when the server reaches MaxConnections.
+	ErrTooManyConnections = errors.New("too many connections")
+	// ErrTooManyConnectionsPerIP occurs when a source IP reaches MaxConnectionsPerIP.
+	ErrTooManyConnectionsPerIP = errors.New("too many connections from the same ip")
+	// ErrConnectionDenied occurs when the OnAccept hook refuses a connection.
+	ErrConnectionDenied = errors.New("connection denied")
 )
+
+// IsIncompleteFrame 解码时数据还不够一帧, 需要等待更多数据, 不是真正的错误;
+// 自定义 codec 返回 ErrIncompleteFrame 或者包装了它的错误
+func IsIncompleteFrame(err error) bool {
+	return errors.Is(err, ErrIncompleteFrame)
+}
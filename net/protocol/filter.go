@@ -0,0 +1,81 @@
+package protocol
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// CIDRFilter 按客户端 IP 的 allow/deny 列表过滤连接, 用法: protocol.OnAccept(filter.Accept)
+// 先匹配 deny, 命中则拒绝; allow 不为空时只接受命中 allow 的地址; 没有 IP 的地址(unix socket)总是接受
+type CIDRFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewCIDRFilter allow/deny 为 CIDR("10.0.0.0/8") 或者单个 IP("10.0.0.1")
+func NewCIDRFilter(allow, deny []string) (*CIDRFilter, error) {
+	var (
+		filter CIDRFilter
+		err    error
+	)
+	if filter.allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if filter.deny, err = parseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	return &filter, nil
+}
+
+// Accept 满足 OnAcceptCallback
+func (this *CIDRFilter) Accept(fd int, sa unix.Sockaddr) bool {
+	var ip net.IP
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		ip = net.IP(sa.Addr[:])
+	case *unix.SockaddrInet6:
+		ip = net.IP(sa.Addr[:])
+	default:
+		return true
+	}
+	return this.AcceptIP(ip)
+}
+
+// AcceptIP 判断一个 IP 是否允许连接
+func (this *CIDRFilter) AcceptIP(ip net.IP) bool {
+	if containsIP(this.deny, ip) {
+		return false
+	}
+	return len(this.allow) == 0 || containsIP(this.allow, ip)
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, err
+			}
+			bits := net.IPv6len * 8
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, net.IPv4len*8
+			}
+			ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		// IPNet.Contains 会把 v4-mapped 的 IPv6 地址当作 IPv4 处理
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package protocol
+
+import (
+	"golang.org/x/sys/unix"
+	"net"
+	"testing"
+)
+
+func TestCIDRFilter(t *testing.T) {
+	filter, err := NewCIDRFilter([]string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"}, []string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ipTest = []struct {
+		ip     string
+		expect bool
+	}{
+		{"10.0.0.1", true},
+		{"10.1.2.3", false},
+		{"192.168.1.1", true},
+		{"192.168.1.2", false},
+		{"::ffff:10.0.0.1", true},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+	}
+	for _, tt := range ipTest {
+		if got := filter.AcceptIP(net.ParseIP(tt.ip)); got != tt.expect {
+			t.Fatalf("ip[%s] expect %v, get %v", tt.ip, tt.expect, got)
+		}
+	}
+
+	if !filter.Accept(0, &unix.SockaddrInet4{Addr: [4]byte{10, 0, 0, 1}}) {
+		t.Fatal("expect sockaddr 10.0.0.1 accepted")
+	}
+	if filter.Accept(0, &unix.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}) {
+		t.Fatal("expect sockaddr 127.0.0.1 denied")
+	}
+	if !filter.Accept(0, &unix.SockaddrUnix{}) {
+		t.Fatal("expect unix socket accepted")
+	}
+
+	if _, err = NewCIDRFilter([]string{"not an ip"}, nil); err == nil {
+		t.Fatal("expect parse error")
+	}
+
+	// 只有 deny 时其余地址都接受
+	denyOnly, err := NewCIDRFilter(nil, []string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if denyOnly.AcceptIP(net.ParseIP("127.0.0.1")) || !denyOnly.AcceptIP(net.ParseIP("8.8.8.8")) {
+		t.Fatal("unexpected deny only result")
+	}
+}
@@ -1,7 +1,11 @@
 package protocol
 
 import (
+	"crypto/tls"
 	"time"
+
+	"github.com/zput/zput_net_golang/net/log"
+	"github.com/zput/zput_net_golang/net/metrics"
 )
 
 // Options 服务配置
@@ -14,8 +18,99 @@ type Options struct {
 	IdleTime  time.Duration
 
 	codeImp ICodec
+
+	retry *Backoff
+	halfCloseOnShutdown bool
+
+	tlsConfig           *tls.Config
+	tlsHandshakeTimeout time.Duration
+
+	highWatermark   int
+	lowWatermark    int
+	maxOutputBuffer int
+	overflowPolicy  OverflowPolicy
+
+	edgeTriggered bool
+
+	loadBalancing LoadBalancing
+	multiAcceptor bool
+
+	acceptBudget        int
+	acceptErrorCallback OnAcceptErrorCallback
+
+	maxConnections          int
+	maxConnectionsPerIP     int
+	limitPolicy             LimitPolicy
+	connectRejectedCallback OnConnectRejectedCallback
+	acceptCallback          OnAcceptCallback
+
+	metrics *metrics.Registry
+	logger  *log.FieldLogger
+
+	decodeErrorCallback OnDecodeErrorCallback
+	maxBufferedBytes    int
+
+	workers            int
+	workerQueueLength  int
+	workerRejectPolicy WorkerRejectPolicy
 }
 
+// LimitPolicy 达到最大连接数时的处理方式
+type LimitPolicy int
+
+const (
+	// LimitReject accept 之后立即关闭
+	LimitReject LimitPolicy = iota
+	// LimitPauseAccept 暂停监听, 连接数降到上限以下再恢复; 单个 IP 超过上限时仍然是立即关闭
+	LimitPauseAccept
+)
+
+// LoadBalancing 新连接分配到 sub loop 的策略
+type LoadBalancing int
+
+const (
+	// RoundRobin 轮询
+	RoundRobin LoadBalancing = iota
+	// LeastConnections 当前连接数最少的 loop
+	LeastConnections
+	// SourceAddrHash 按客户端 IP 哈希, 同一个客户端总是分配到同一个 loop
+	SourceAddrHash
+)
+
+// OverflowPolicy 发送缓冲区超过上限时的处理方式
+type OverflowPolicy int
+
+const (
+	// OverflowClose 关闭连接
+	OverflowClose OverflowPolicy = iota
+	// OverflowDrop 丢弃这次写入的数据
+	OverflowDrop
+)
+
+// DecodeErrorPolicy 解码失败时的处理方式
+type DecodeErrorPolicy int
+
+const (
+	// DecodeErrorClose 关闭连接
+	DecodeErrorClose DecodeErrorPolicy = iota
+	// DecodeErrorSkip 继续解码; 回调中可以用 ShiftN 跳过坏数据, 没有跳过任何字节时丢弃接收缓冲区中的全部数据
+	DecodeErrorSkip
+	// DecodeErrorIgnore 保留数据, 停止这一轮解码, 下次收到数据再试; 一般和 MaxBufferedBytes 一起使用
+	DecodeErrorIgnore
+)
+
+// WorkerRejectPolicy worker pool 排队的任务达到上限时的处理方式
+type WorkerRejectPolicy int
+
+const (
+	// WorkerRejectClose 关闭连接
+	WorkerRejectClose WorkerRejectPolicy = iota
+	// WorkerRejectDrop 丢弃这一帧
+	WorkerRejectDrop
+	// WorkerRejectBlock 阻塞 loop 直到有空位, 同一个 loop 上的连接都会停止读写
+	WorkerRejectBlock
+)
+
 // Option ...
 type Option func(*Options)
 
@@ -35,6 +130,94 @@ func(this *Options)GetCode() ICodec {
 	return this.codeImp
 }
 
+func(this *Options)GetRetry() *Backoff {
+	return this.retry
+}
+
+func(this *Options)GetHalfCloseOnShutdown() bool {
+	return this.halfCloseOnShutdown
+}
+
+func(this *Options)GetTLSConfig() *tls.Config {
+	return this.tlsConfig
+}
+
+func(this *Options)GetTLSHandshakeTimeout() time.Duration {
+	return this.tlsHandshakeTimeout
+}
+
+func(this *Options)GetHighWatermark() int {
+	return this.highWatermark
+}
+
+func(this *Options)GetLowWatermark() int {
+	return this.lowWatermark
+}
+
+func(this *Options)GetMaxOutputBuffer() (int, OverflowPolicy) {
+	return this.maxOutputBuffer, this.overflowPolicy
+}
+
+func(this *Options)GetEdgeTriggered() bool {
+	return this.edgeTriggered
+}
+
+func(this *Options)GetLoadBalancing() LoadBalancing {
+	return this.loadBalancing
+}
+
+func(this *Options)GetMultiAcceptor() bool {
+	return this.multiAcceptor
+}
+
+func(this *Options)GetAcceptBudget() int {
+	return this.acceptBudget
+}
+
+func(this *Options)GetAcceptErrorCallback() OnAcceptErrorCallback {
+	return this.acceptErrorCallback
+}
+
+func(this *Options)GetMaxConnections() int {
+	return this.maxConnections
+}
+
+func(this *Options)GetMaxConnectionsPerIP() int {
+	return this.maxConnectionsPerIP
+}
+
+func(this *Options)GetLimitPolicy() LimitPolicy {
+	return this.limitPolicy
+}
+
+func(this *Options)GetConnectRejectedCallback() OnConnectRejectedCallback {
+	return this.connectRejectedCallback
+}
+
+func(this *Options)GetAcceptCallback() OnAcceptCallback {
+	return this.acceptCallback
+}
+
+func(this *Options)GetMetrics() *metrics.Registry {
+	return this.metrics
+}
+
+func(this *Options)GetLogger() *log.FieldLogger {
+	return this.logger
+}
+
+func(this *Options)GetDecodeErrorCallback() OnDecodeErrorCallback {
+	return this.decodeErrorCallback
+}
+
+func(this *Options)GetMaxBufferedBytes() int {
+	return this.maxBufferedBytes
+}
+
+func(this *Options)GetWorkerPool() (int, int, WorkerRejectPolicy) {
+	return this.workers, this.workerQueueLength, this.workerRejectPolicy
+}
+
 func NewOptions(opt ...Option) *Options {
 	opts := Options{}
 
@@ -54,6 +237,18 @@ func NewOptions(opt ...Option) *Options {
 	if opts.wheelSize == 0 {
 		opts.wheelSize = 1000
 	}
+	if opts.tlsHandshakeTimeout == 0 {
+		opts.tlsHandshakeTimeout = 10 * time.Second
+	}
+	if opts.lowWatermark > opts.highWatermark {
+		opts.lowWatermark = opts.highWatermark
+	}
+	if opts.acceptBudget <= 0 {
+		opts.acceptBudget = DefaultAcceptBudget
+	}
+	if opts.logger == nil {
+		opts.logger = log.Default()
+	}
 	if opts.codeImp == nil{
 		// TODO
 		opts.codeImp = new(BuiltInFrameCodec)
@@ -101,3 +296,158 @@ func CodeImp(codeImp ICodec) Option {
 		o.codeImp = codeImp
 	}
 }
+
+// Retry 客户端连接失败或断开后按指数退避重连
+func Retry(backoff Backoff) Option {
+	return func(o *Options) {
+		o.retry = &backoff
+	}
+}
+
+// HalfCloseOnShutdown Shutdown 时写完数据后只关闭写端, 等待对端关闭连接
+func HalfCloseOnShutdown(halfClose bool) Option {
+	return func(o *Options) {
+		o.halfCloseOnShutdown = halfClose
+	}
+}
+
+// TLSConfig 开启 TLS; 多个证书时按 SNI 选择(Certificates 或 GetCertificate), ALPN 通过 NextProtos 配置
+func TLSConfig(config *tls.Config) Option {
+	return func(o *Options) {
+		o.tlsConfig = config
+	}
+}
+
+// TLSHandshakeTimeout 握手超时后关闭连接, 默认 10s, 负数表示不限制
+func TLSHandshakeTimeout(t time.Duration) Option {
+	return func(o *Options) {
+		o.tlsHandshakeTimeout = t
+	}
+}
+
+// Watermark 发送缓冲区达到 high 时回调 OnHighWatermark, 之后回落到 low 时回调 OnLowWatermark; high <= 0 表示关闭
+func Watermark(high, low int) Option {
+	return func(o *Options) {
+		o.highWatermark = high
+		o.lowWatermark = low
+	}
+}
+
+// MaxOutputBuffer 发送缓冲区硬上限, 只计算直接写 socket 之后还要缓冲的部分, 超过时按 policy 关闭连接或丢弃数据;
+// TLS 连接以及这次已经写出一部分时总是关闭
+func MaxOutputBuffer(max int, policy OverflowPolicy) Option {
+	return func(o *Options) {
+		o.maxOutputBuffer = max
+		o.overflowPolicy = policy
+	}
+}
+
+// EdgeTriggered sub loop 使用边沿触发(epoll EPOLLET / kqueue EV_CLEAR), 每次读写到 EAGAIN; 默认水平触发
+func EdgeTriggered(edgeTriggered bool) Option {
+	return func(o *Options) {
+		o.edgeTriggered = edgeTriggered
+	}
+}
+
+// LoadBalance 新连接分配到 sub loop 的策略, 默认 RoundRobin
+func LoadBalance(lb LoadBalancing) Option {
+	return func(o *Options) {
+		o.loadBalancing = lb
+	}
+}
+
+// MultiAcceptor 每个 sub loop 各自监听一个 SO_REUSEPORT socket 并 accept, 由内核分配连接,
+// 连接直接在 accept 它的 loop 上创建, 不经过 mainLoop, 这时 LoadBalance 不起作用; unix socket 不支持
+func MultiAcceptor(multiAcceptor bool) Option {
+	return func(o *Options) {
+		o.multiAcceptor = multiAcceptor
+	}
+}
+
+// AcceptBudget 每次可读事件最多 accept 的连接数, 默认 DefaultAcceptBudget
+func AcceptBudget(n int) Option {
+	return func(o *Options) {
+		o.acceptBudget = n
+	}
+}
+
+// OnAcceptError accept 出错时回调, 例如 EMFILE; 回调在 accept 所在的 loop 中执行
+func OnAcceptError(cb OnAcceptErrorCallback) Option {
+	return func(o *Options) {
+		o.acceptErrorCallback = cb
+	}
+}
+
+// MaxConnections 最大连接数, 0 表示不限制
+func MaxConnections(n int) Option {
+	return func(o *Options) {
+		o.maxConnections = n
+	}
+}
+
+// MaxConnectionsPerIP 同一个客户端 IP 的最大连接数, 0 表示不限制; unix socket 不受限制
+func MaxConnectionsPerIP(n int) Option {
+	return func(o *Options) {
+		o.maxConnectionsPerIP = n
+	}
+}
+
+// ConnectionLimitPolicy 达到 MaxConnections 时的处理方式, 默认 LimitReject
+func ConnectionLimitPolicy(policy LimitPolicy) Option {
+	return func(o *Options) {
+		o.limitPolicy = policy
+	}
+}
+
+// OnConnectRejected 连接被 OnAccept 拒绝或者超过连接数限制被关闭时回调, 在 accept 所在的 loop 中执行
+func OnConnectRejected(cb OnConnectRejectedCallback) Option {
+	return func(o *Options) {
+		o.connectRejectedCallback = cb
+	}
+}
+
+// OnAccept 在分配任何缓冲区之前过滤新连接, 例如 CIDRFilter.Accept 或者按 IP 限速;
+// 在 accept 所在的 loop 中执行, 先于连接数限制
+func OnAccept(cb OnAcceptCallback) Option {
+	return func(o *Options) {
+		o.acceptCallback = cb
+	}
+}
+
+// Metrics 开启指标, 注册到 registry; 通过 registry.Handler() 以 Prometheus 文本格式输出
+func Metrics(registry *metrics.Registry) Option {
+	return func(o *Options) {
+		o.metrics = registry
+	}
+}
+
+// Logger 这个 Server/Client 使用的结构化日志, 默认输出到全局的 log; 连接的日志会带上 fd/peer/loop/conn 字段
+func Logger(logger *log.FieldLogger) Option {
+	return func(o *Options) {
+		o.logger = logger
+	}
+}
+
+// OnDecodeError 解码失败时回调, 按返回的策略处理; 不设置时关闭连接
+func OnDecodeError(cb OnDecodeErrorCallback) Option {
+	return func(o *Options) {
+		o.decodeErrorCallback = cb
+	}
+}
+
+// MaxBufferedBytes 接收缓冲区中还没解码成帧的数据的上限, 超过后关闭连接; 0 表示不限制
+func MaxBufferedBytes(max int) Option {
+	return func(o *Options) {
+		o.maxBufferedBytes = max
+	}
+}
+
+// WorkerPool MessageCallback 不在 sub loop 中执行, 而是交给 size 个协程的 worker pool, 同一个连接的帧按顺序处理;
+// 回调返回的数据回到连接所在的 loop 编码写出; 所有连接排队的帧超过 queueLength 时按 policy 处理; size <= 0 表示关闭; 只对 Server 生效
+func WorkerPool(size, queueLength int, policy WorkerRejectPolicy) Option {
+	return func(o *Options) {
+		o.workers = size
+		o.workerQueueLength = queueLength
+		o.workerRejectPolicy = policy
+	}
+}
@@ -34,6 +34,18 @@ type NetWorkAndAddressAndOption struct {
 // TCP accept 处理新连接
 type OnNewConnectCallback func(fd int, sa unix.Sockaddr)
 
+// accept 之后、创建 Connect 之前决定是否接受这个连接, 返回 false 时关闭 fd
+type OnAcceptCallback func(fd int, sa unix.Sockaddr) bool
+
+// 被拒绝的连接, err 为 ErrConnectionDenied、ErrTooManyConnections 或 ErrTooManyConnectionsPerIP
+type OnConnectRejectedCallback func(sa unix.Sockaddr, err error)
+
+// 解码失败(ErrIncompleteFrame 之外的错误)时决定如何处理, c 为 *connect.Connect
+type OnDecodeErrorCallback func(c Conn, err error) DecodeErrorPolicy
+
+// accept 失败(EAGAIN、ECONNABORTED 之外的错误)
+type OnAcceptErrorCallback func(err error)
+
 type AddFunToLoopWaitingRun func()
 
 // ErrClosed 重复 close poller 错误
@@ -41,6 +53,9 @@ var ErrClosed = errors.New("poller instance is not running")
 
 const WaitEventsNumber = 1024
 
+// DefaultAcceptBudget 每次可读事件默认最多 accept 的连接数
+const DefaultAcceptBudget = 64
+
 type ICode interface {
 	Encode([]byte)[]byte
 	DeCode([]byte)([]byte, error)
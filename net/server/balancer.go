@@ -0,0 +1,86 @@
+package tcpserver
+
+import (
+	"hash/fnv"
+
+	"github.com/zput/zput_net_golang/net/event_loop"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"golang.org/x/sys/unix"
+)
+
+// LoadBalancer 为新连接选择 sub loop
+type LoadBalancer interface {
+	// Register 按顺序注册每个 sub loop, 在 Start 之前调用
+	Register(loop *event_loop.EventLoop)
+	// Next 为来自 sa 的新连接选择 loop, 在 mainLoop 中调用
+	Next(sa unix.Sockaddr) *event_loop.EventLoop
+}
+
+// NewLoadBalancer 内置的负载均衡策略
+func NewLoadBalancer(lb protocol.LoadBalancing) LoadBalancer {
+	switch lb {
+	case protocol.LeastConnections:
+		return new(leastConnectionsLoadBalancer)
+	case protocol.SourceAddrHash:
+		return new(sourceAddrHashLoadBalancer)
+	default:
+		return new(roundRobinLoadBalancer)
+	}
+}
+
+// roundRobinLoadBalancer 轮询
+type roundRobinLoadBalancer struct {
+	loops         []*event_loop.EventLoop
+	nextLoopIndex int
+}
+
+func (this *roundRobinLoadBalancer) Register(loop *event_loop.EventLoop) {
+	this.loops = append(this.loops, loop)
+}
+
+func (this *roundRobinLoadBalancer) Next(sa unix.Sockaddr) *event_loop.EventLoop {
+	loop := this.loops[this.nextLoopIndex]
+	this.nextLoopIndex = (this.nextLoopIndex + 1) % len(this.loops)
+	return loop
+}
+
+// leastConnectionsLoadBalancer 选择当前连接数最少的 loop, 相同时选择靠前的
+type leastConnectionsLoadBalancer struct {
+	loops []*event_loop.EventLoop
+}
+
+func (this *leastConnectionsLoadBalancer) Register(loop *event_loop.EventLoop) {
+	this.loops = append(this.loops, loop)
+}
+
+func (this *leastConnectionsLoadBalancer) Next(sa unix.Sockaddr) *event_loop.EventLoop {
+	loop := this.loops[0]
+	for _, l := range this.loops[1:] {
+		if l.ConnectCount() < loop.ConnectCount() {
+			loop = l
+		}
+	}
+	return loop
+}
+
+// sourceAddrHashLoadBalancer 按客户端 IP 哈希, 同一个客户端的连接总是在同一个 loop;
+// 没有 IP 的地址(unix socket)退化为轮询
+type sourceAddrHashLoadBalancer struct {
+	roundRobinLoadBalancer
+}
+
+func (this *sourceAddrHashLoadBalancer) Next(sa unix.Sockaddr) *event_loop.EventLoop {
+	var ip []byte
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		ip = sa.Addr[:]
+	case *unix.SockaddrInet6:
+		ip = sa.Addr[:]
+	default:
+		return this.roundRobinLoadBalancer.Next(sa)
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write(ip)
+	return this.loops[h.Sum32()%uint32(len(this.loops))]
+}
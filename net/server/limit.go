@@ -0,0 +1,91 @@
+package tcpserver
+
+import (
+	"net"
+	"sync"
+
+	"github.com/zput/zput_net_golang/net/protocol"
+	"golang.org/x/sys/unix"
+)
+
+// connectLimiter 统计总连接数和每个客户端 IP 的连接数; accept 和关闭连接在不同的 loop, 需要加锁
+type connectLimiter struct {
+	mutex    sync.Mutex
+	max      int
+	maxPerIP int
+	total    int
+	perIP    map[string]int
+
+	// 达到上限/降到上限以下, 在锁内调用, 保证暂停和恢复的顺序
+	onFull  func()
+	onBelow func()
+}
+
+func newConnectLimiter(max, maxPerIP int) *connectLimiter {
+	return &connectLimiter{
+		max:      max,
+		maxPerIP: maxPerIP,
+		perIP:    make(map[string]int),
+	}
+}
+
+// acquire 为来自 ip 的新连接占一个名额; ip 为空(unix socket)时不限制单个 IP
+func (this *connectLimiter) acquire(ip string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.max > 0 && this.total >= this.max {
+		return protocol.ErrTooManyConnections
+	}
+	countPerIP := this.maxPerIP > 0 && ip != ""
+	if countPerIP && this.perIP[ip] >= this.maxPerIP {
+		return protocol.ErrTooManyConnectionsPerIP
+	}
+
+	this.total++
+	if countPerIP {
+		this.perIP[ip]++
+	}
+	if this.max > 0 && this.total == this.max && this.onFull != nil {
+		this.onFull()
+	}
+	return nil
+}
+
+func (this *connectLimiter) release(ip string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.total--
+	if this.maxPerIP > 0 && ip != "" {
+		if this.perIP[ip] <= 1 {
+			delete(this.perIP, ip)
+		} else {
+			this.perIP[ip]--
+		}
+	}
+	if this.max > 0 && this.total == this.max-1 && this.onBelow != nil {
+		this.onBelow()
+	}
+}
+
+// sourceIP 客户端 IP, 和 PeerAddr 中的 host 部分一致
+func sourceIP(sa unix.Sockaddr) string {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return net.IP(sa.Addr[:]).String()
+	case *unix.SockaddrInet6:
+		return net.IP(sa.Addr[:]).String()
+	default:
+		return ""
+	}
+}
+
+// peerIP 从 PeerAddr 中取出 IP, unix socket 为空
+func peerIP(peerAddr string) string {
+	host, _, err := net.SplitHostPort(peerAddr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
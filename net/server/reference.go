@@ -9,6 +9,10 @@ type IHandleEvent interface {
 	MessageCallback(*connect.Connect, []byte)[]byte
 	WriteCompletCallback(*connect.Connect)
 	ConnectCloseCallback(*connect.Connect)
+	// OnHighWatermark 发送缓冲区达到高水位, 可以暂停向这个连接推送
+	OnHighWatermark(c *connect.Connect, size int)
+	// OnLowWatermark 发送缓冲区回落到低水位
+	OnLowWatermark(c *connect.Connect, size int)
 }
 
 type HandleEventImpl struct{}
@@ -30,3 +34,11 @@ func(this *HandleEventImpl)ConnectCloseCallback(c *connect.Connect){
 	//log.Infof("connect close:[%s]", c.PeerAddr())
 }
 
+
+func(this *HandleEventImpl)OnHighWatermark(c *connect.Connect, size int){
+	//log.Infof("connect:[%s] high watermark[%d]", c.PeerAddr(), size)
+}
+
+func(this *HandleEventImpl)OnLowWatermark(c *connect.Connect, size int){
+	//log.Infof("connect:[%s] low watermark[%d]", c.PeerAddr(), size)
+}
@@ -0,0 +1,80 @@
+package tcpserver
+
+import (
+	"sync"
+
+	"github.com/zput/zput_net_golang/net/connect"
+)
+
+// ConnectRegistry 并发安全的连接表, 以 Connect.ID() 为 key; main loop 添加, 各个 sub loop 删除
+type ConnectRegistry struct {
+	mutex    sync.RWMutex
+	connects map[uint64]*connect.Connect
+}
+
+func NewConnectRegistry() *ConnectRegistry {
+	return &ConnectRegistry{
+		connects: make(map[uint64]*connect.Connect),
+	}
+}
+
+func (this *ConnectRegistry) add(id uint64, c *connect.Connect) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.connects[id] = c
+}
+
+func (this *ConnectRegistry) remove(id uint64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	delete(this.connects, id)
+}
+
+// Len 当前连接数
+func (this *ConnectRegistry) Len() int {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	return len(this.connects)
+}
+
+// Get 按 id 查找连接
+func (this *ConnectRegistry) Get(id uint64) (*connect.Connect, bool) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	c, ok := this.connects[id]
+	return c, ok
+}
+
+// Range 遍历连接快照, fn 返回 false 时停止; fn 中可以关闭连接
+func (this *ConnectRegistry) Range(fn func(id uint64, c *connect.Connect) bool) {
+	for id, c := range this.snapshot() {
+		if !fn(id, c) {
+			return
+		}
+	}
+}
+
+// Broadcast 把 data 交给每个连接自己的 loop 发送, 返回成功投递的连接数;
+// data 会被多个 loop 同时读取, 调用之后不要再修改
+func (this *ConnectRegistry) Broadcast(data []byte) int {
+	var n int
+	for _, c := range this.snapshot() {
+		if c == nil {
+			continue
+		}
+		if err := c.WriteInSelfLoop(data); err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (this *ConnectRegistry) snapshot() map[uint64]*connect.Connect {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	connects := make(map[uint64]*connect.Connect, len(this.connects))
+	for k, v := range this.connects {
+		connects[k] = v
+	}
+	return connects
+}
@@ -6,8 +6,11 @@ import (
 	"github.com/zput/zput_net_golang/net/connect"
 	"github.com/zput/zput_net_golang/net/event_loop"
 	"github.com/zput/zput_net_golang/net/log"
+	"github.com/zput/zput_net_golang/net/metrics"
 	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/worker"
 	"golang.org/x/sys/unix"
+	"fmt"
 	"runtime"
 	"time"
 )
@@ -17,9 +20,19 @@ type Server struct{
 	handleEvent IHandleEvent
 	mainLoop *event_loop.EventLoop
 	subLoops []*event_loop.EventLoop
-	tcpAccept *accept.Accept
-	connectPool map[string]*connect.Connect
-	nextLoopIndex int
+	// 默认只有 mainLoop 上的一个; MultiAcceptor 时每个 sub loop 一个
+	acceptors []*accept.Accept
+	connects *ConnectRegistry
+	loadBalancer LoadBalancer
+	// 没有配置连接数限制时为 nil
+	limiter *connectLimiter
+	rejected protocol.Int64
+	stopped protocol.Bool
+	// 没有开启指标时为 nil
+	connectMetrics *metrics.ConnectMetrics
+	logger *log.FieldLogger
+	// 没有开启 WorkerPool 时为 nil
+	workerPool *worker.Pool
 
 	timingWheel *timingwheel.TimingWheel
 }
@@ -27,31 +40,23 @@ type Server struct{
 func New(handleEvent IHandleEvent, opts ...protocol.Option)(*Server, error){
 	var err error
 
+	options := protocol.NewOptions(opts...)
 	mainLoop, err := event_loop.New(-1)
 	if err != nil{
-		log.Errorf("new mainLoop error[%v]", err)
+		options.GetLogger().Error("new main loop failed", log.Err(err))
 		return nil, err
 	}
 
 	var tcpServer = Server{
 		handleEvent:handleEvent,
 		mainLoop:mainLoop,
-		options:protocol.NewOptions(opts...),
-		connectPool:make(map[string]*connect.Connect),
+		options:options,
+		connects:NewConnectRegistry(),
+		logger:options.GetLogger(),
 	}
 
 	tcpServer.timingWheel = timingwheel.NewTimingWheel(tcpServer.options.GetTick(), tcpServer.options.GetWheelSize())
 
-	//创建一个tcp accept
-	tcpServer.tcpAccept, err = accept.New(tcpServer.options.GetNet(), tcpServer.mainLoop)
-	if err != nil{
-		log.Errorf("new accept error[%v]", err)
-		return nil, err
-	}
-
-	//设置有连接到来后,的回调函数.
-	tcpServer.tcpAccept.SetNewConnectCallback(tcpServer.newConnected)
-
 	if tcpServer.options.NumLoops <= 0 {
 		tcpServer.options.NumLoops = runtime.NumCPU()
 	}
@@ -67,20 +72,136 @@ func New(handleEvent IHandleEvent, opts ...protocol.Option)(*Server, error){
 			}
 			return nil, err
 		}
+		// 只有 sub loop 使用边沿触发, mainLoop 上的 accept 保持水平触发
+		l.SetEdgeTriggered(tcpServer.options.GetEdgeTriggered())
 		runloops[i] = l
 	}
 	tcpServer.subLoops = runloops
+	tcpServer.SetLoadBalancer(NewLoadBalancer(tcpServer.options.GetLoadBalancing()))
+
+	if max, maxPerIP := tcpServer.options.GetMaxConnections(), tcpServer.options.GetMaxConnectionsPerIP(); max > 0 || maxPerIP > 0 {
+		tcpServer.limiter = newConnectLimiter(max, maxPerIP)
+		if tcpServer.options.GetLimitPolicy() == protocol.LimitPauseAccept {
+			tcpServer.limiter.onFull = tcpServer.pauseAcceptors
+			tcpServer.limiter.onBelow = tcpServer.resumeAcceptors
+		}
+	}
+
+	if size, queueLength, _ := tcpServer.options.GetWorkerPool(); size > 0 {
+		tcpServer.workerPool = worker.New(size, queueLength)
+		tcpServer.workerPool.SetLogger(tcpServer.logger)
+	}
+
+	if registry := tcpServer.options.GetMetrics(); registry != nil {
+		tcpServer.registerMetrics(registry)
+	}
+
+	if err = tcpServer.newAcceptors(); err != nil{
+		return nil, err
+	}
 
 	return &tcpServer, nil
 }
 
+func (this *Server) registerMetrics(registry *metrics.Registry) {
+	this.connectMetrics = metrics.NewConnectMetrics(registry, fmt.Sprintf("%T", this.options.GetCode()))
+	registry.GaugeFunc("connections_active", "Connections currently open.", nil, func() float64 {
+		return float64(this.connects.Len())
+	})
+	registry.CounterFunc("connections_rejected_total", "Connections rejected by OnAccept or connection limits.", nil, func() float64 {
+		return float64(this.rejected.Get())
+	})
+
+	if this.workerPool != nil {
+		registry.GaugeFunc("worker_pending_tasks", "Frames queued or running on the worker pool.", nil, func() float64 {
+			return float64(this.workerPool.Pending())
+		})
+	}
+
+	this.mainLoop.SetMetrics(metrics.NewLoopMetrics(registry, this.mainLoop.SequenceID, this.mainLoop.PendingTasks))
+	for _, loop := range this.subLoops {
+		loop.SetMetrics(metrics.NewLoopMetrics(registry, loop.SequenceID, loop.PendingTasks))
+	}
+}
+
+// newAcceptors 默认在 mainLoop 上 accept, 再把连接交给 sub loop;
+// MultiAcceptor 时每个 sub loop 各自监听一个 reuseport socket, 由内核分配连接, 连接直接在本 loop 创建
+func (this *Server) newAcceptors() error {
+	option := this.options.GetNet()
+	if !this.options.GetMultiAcceptor() || option.Network == "unix" {
+		//创建一个tcp accept
+		tcpAccept, err := accept.New(option, this.mainLoop)
+		if err != nil{
+			this.logger.Error("new accept failed", log.F("listen", option.Address), log.Err(err))
+			return err
+		}
+		//设置有连接到来后,的回调函数.
+		tcpAccept.SetNewConnectCallback(this.newConnected)
+		this.setAcceptOptions(tcpAccept)
+		this.acceptors = append(this.acceptors, tcpAccept)
+		return nil
+	}
+
+	option.ReusePort = true
+	for _, loop := range this.subLoops {
+		tcpAccept, err := accept.New(option, loop)
+		if err != nil{
+			this.logger.Error("new accept failed", log.F("listen", option.Address), log.Loop(loop.SequenceID), log.Err(err))
+			this.closeAcceptors()
+			return err
+		}
+		tcpAccept.SetNewConnectCallback(this.newConnectedInLoop(loop))
+		this.setAcceptOptions(tcpAccept)
+		this.acceptors = append(this.acceptors, tcpAccept)
+	}
+	return nil
+}
+
+func (this *Server) setAcceptOptions(tcpAccept *accept.Accept) {
+	tcpAccept.SetLogger(this.logger)
+	tcpAccept.SetAcceptBudget(this.options.GetAcceptBudget())
+	tcpAccept.SetAcceptErrorCallback(this.options.GetAcceptErrorCallback())
+}
+
+func (this *Server) pauseAcceptors() {
+	for _, tcpAccept := range this.acceptors {
+		tcpAccept.Pause()
+	}
+}
+
+func (this *Server) resumeAcceptors() {
+	for _, tcpAccept := range this.acceptors {
+		tcpAccept.Resume()
+	}
+}
+
+func (this *Server) closeAcceptors() {
+	for _, tcpAccept := range this.acceptors {
+		if err := tcpAccept.Close(); err != nil{
+			this.logger.Error("close accept failed", log.Err(err))
+		}
+	}
+}
+
+// SetLoadBalancer 替换为自定义的负载均衡策略, 需要在 Start 之前调用
+func (this *Server) SetLoadBalancer(loadBalancer LoadBalancer) {
+	for _, loop := range this.subLoops {
+		loadBalancer.Register(loop)
+	}
+	this.loadBalancer = loadBalancer
+}
+
 // Start 启动 Server
 func (this *Server) Start() {
 	this.timingWheel.Start()
+	if this.workerPool != nil {
+		this.workerPool.Start()
+	}
 
-	err := this.tcpAccept.Listen()
-	if err != nil{
-		panic(err)
+	for _, tcpAccept := range this.acceptors {
+		if err := tcpAccept.Listen(); err != nil{
+			panic(err)
+		}
 	}
 
 	sw := protocol.WaitGroupWrapper{}
@@ -97,37 +218,77 @@ func (this *Server) Start() {
 
 // 停止系统。
 func (this *Server) Stop() {
+	if this.stopped.Set(true) {
+		return
+	}
 	//先关闭tcpaccept, tcpconnect，然后再关闭loop
-	var (
-		err error
-	)
-
 	this.timingWheel.Stop()
 
-	err = this.tcpAccept.Close()
-	if err != nil{
-		log.Error(err)
-	}
-	for  k, v := range this.connectPool{
-	    err = v.Close()
+	this.closeAcceptors()
+	for  k, v := range this.connects.snapshot(){
+	    err := v.Close()
 		if err != nil{
-			log.Errorf("closed [%s] failure, error[%v]", k, err)
+			this.logger.Error("close connection failed", log.ConnID(k), log.Err(err))
 		}
 	}
+	this.stopLoops()
+	this.stopWorkerPool()
+}
+
+// stopWorkerPool loop 停止之后不会再有新的帧, 等待正在执行的回调结束
+func (this *Server) stopWorkerPool() {
+	if this.workerPool != nil {
+		this.workerPool.Stop()
+	}
+}
+
+func (this *Server) stopLoops() {
+	var (
+		err error
+	)
 	//关闭accept AND main loop
 	err = this.mainLoop.Stop()
 	if err != nil{
-		log.Error(err)
+		this.logger.Error("stop loop failed", log.Loop(this.mainLoop.SequenceID), log.Err(err))
 	}
 	//关闭connect loop
 	for index := range this.subLoops{
 		err = this.subLoops[index].Stop()
 		if err != nil{
-			log.Error(err)
+			this.logger.Error("stop loop failed", log.Loop(this.subLoops[index].SequenceID), log.Err(err))
 		}
 	}
 }
 
+// Connects 当前所有连接, 可以在任意协程中查找、遍历、广播
+func (this *Server) Connects() *ConnectRegistry {
+	return this.connects
+}
+
+// SubLoops 处理连接的 loop, 可以交给 tcpclient 共用
+func (this *Server) SubLoops() []*event_loop.EventLoop {
+	return this.subLoops
+}
+
+// Rejected 被 OnAccept 拒绝或者因为超过连接数限制被关闭的连接总数
+func (this *Server) Rejected() int64 {
+	return this.rejected.Get()
+}
+
+// LoopConnectCounts 每个 sub loop 上的连接数, 顺序和 SubLoops 一致
+func (this *Server) LoopConnectCounts() []int64 {
+	counts := make([]int64, len(this.subLoops))
+	for i, loop := range this.subLoops {
+		counts[i] = loop.ConnectCount()
+	}
+	return counts
+}
+
+// TimingWheel 服务使用的时间轮
+func (this *Server) TimingWheel() *timingwheel.TimingWheel {
+	return this.timingWheel
+}
+
 // RunAfter 延时任务
 func (this *Server) RunAfter(d time.Duration, f func()) *timingwheel.Timer {
 	return this.timingWheel.AfterFunc(d, f)
@@ -138,50 +299,127 @@ func (this *Server) RunEvery(d time.Duration, f func()) *timingwheel.Timer {
 	return this.timingWheel.ScheduleFunc(&protocol.EveryScheduler{Interval: d}, f)
 }
 
+// newConnected mainLoop 上 accept 到的连接, 按负载均衡策略交给 sub loop
 func (this *Server) newConnected(fd int, sa unix.Sockaddr){
-	loopTemp := this.getOneLoopFromPool()
+	if !this.admit(fd, sa) {
+		return
+	}
+	loopTemp := this.loadBalancer.Next(sa)
+
+	c := this.newConnect(loopTemp, fd, sa)
+	if c == nil{
+		return
+	}
+	loopTemp.RunInLoop(func(){
+		this.connectEstablished(c)
+	})
+}
 
+// newConnectedInLoop sub loop 自己 accept 到的连接, 不需要再切换 loop
+func (this *Server) newConnectedInLoop(loop *event_loop.EventLoop) protocol.OnNewConnectCallback {
+	return func(fd int, sa unix.Sockaddr){
+		if !this.admit(fd, sa) {
+			return
+		}
+		if c := this.newConnect(loop, fd, sa); c != nil{
+			this.connectEstablished(c)
+		}
+	}
+}
+
+func (this *Server) newConnect(loopTemp *event_loop.EventLoop, fd int, sa unix.Sockaddr) *connect.Connect {
 	c, err := connect.New(loopTemp, fd, sa, this.timingWheel, this.options.IdleTime, this.options.GetCode())
 	if err != nil{
-		log.Errorf("failure to create new connection; error[%v]", err)
-		return
+		this.logger.Error("new connection failed", log.Fd(fd), log.Err(err))
+		_ = unix.Close(fd)
+		if this.limiter != nil {
+			this.limiter.release(sourceIP(sa))
+		}
+		return nil
 	}
+	loopTemp.AddConnectCount(1)
 
-	log.Debugf("a connection[%s] is enter", c.PeerAddr())
+	c.SetLogger(this.logger)
+	c.Logger().Debug("connection accepted")
 
-	this.addConnect(c.PeerAddr(), c)
+	if this.connectMetrics != nil {
+		c.SetMetrics(this.connectMetrics)
+	}
 	c.SetMessageCallback(this.handleEvent.MessageCallback)
 	c.SetConnectCloseCallback(this.connectCloseEvent)
 	c.SetWriteCompleteCallback(this.handleEvent.WriteCompletCallback)
-	loopTemp.RunInLoop(func(){
-		if err := c.ConnectedHandle(); err != nil{
-			c.Close()
-			this.removeConnect(c.PeerAddr())
-		}
-		this.handleEvent.ConnectCallback(c)
-	})
+	if high := this.options.GetHighWatermark(); high > 0 {
+		c.SetHighWatermarkCallback(this.handleEvent.OnHighWatermark, high)
+		c.SetLowWatermarkCallback(this.handleEvent.OnLowWatermark, this.options.GetLowWatermark())
+	}
+	c.SetOutputLimit(this.options.GetMaxOutputBuffer())
+	c.SetDecodeErrorCallback(this.options.GetDecodeErrorCallback())
+	c.SetMaxBufferedBytes(this.options.GetMaxBufferedBytes())
+	if this.workerPool != nil {
+		_, _, policy := this.options.GetWorkerPool()
+		c.SetWorkerPool(this.workerPool, policy)
+	}
+	if config := this.options.GetTLSConfig(); config != nil {
+		// TLS 连接握手完成之后才回调 ConnectCallback
+		c.EnableTLS(config, false, this.options.GetTLSHandshakeTimeout())
+		c.SetHandshakeCallback(this.handleEvent.ConnectCallback)
+	}
+	return c
 }
 
-func (this *Server) getOneLoopFromPool() *event_loop.EventLoop {
-	// TODO hash?
-	loop := this.subLoops[this.nextLoopIndex]
-	this.nextLoopIndex = (this.nextLoopIndex + 1) % len(this.subLoops)
-	return loop
+// connectEstablished 在连接所属的 loop 中调用
+func (this *Server) connectEstablished(c *connect.Connect) {
+	if err := c.ConnectedHandle(); err != nil{
+		// ConnectedHandle 失败时已经关闭了 fd, 不会再回调 connectCloseEvent, 在这里归还一次名额
+		this.releaseConnect(c)
+		return
+	}
+	// ConnectedHandle 之后连接才能写, 这时再加入 registry, Broadcast 不会跳过
+	this.addConnect(c.ID(), c)
+	if !c.IsTLS() {
+		this.handleEvent.ConnectCallback(c)
+	}
 }
 
 func (this *Server) connectCloseEvent(connect *connect.Connect){
 	this.handleEvent.ConnectCloseCallback(connect)
-	this.removeConnect(connect.PeerAddr())
-	log.Debug("in server; delete connect pool")
+	this.removeConnect(connect.ID())
+	this.releaseConnect(connect)
 }
 
-func (this *Server) addConnect(name string, connect *connect.Connect) {
-	this.connectPool[name] = connect
+// admit 在创建 Connect 之前检查 OnAccept 和连接数限制, 拒绝时关闭 fd 并回调
+func (this *Server) admit(fd int, sa unix.Sockaddr) bool {
+	var err error
+	if acceptCallback := this.options.GetAcceptCallback(); acceptCallback != nil && !acceptCallback(fd, sa) {
+		err = protocol.ErrConnectionDenied
+	} else if this.limiter != nil {
+		err = this.limiter.acquire(sourceIP(sa))
+	}
+	if err == nil {
+		return true
+	}
+
+	_ = unix.Close(fd)
+	this.rejected.Add(1)
+	this.logger.Debug("connection rejected", log.Fd(fd), log.Peer(sourceIP(sa)), log.Err(err))
+	if rejectedCallback := this.options.GetConnectRejectedCallback(); rejectedCallback != nil {
+		rejectedCallback(sa, err)
+	}
+	return false
 }
 
-func (this *Server) removeConnect(name string){
-	_, ok := this.connectPool[name]
-	if ok {
-		delete(this.connectPool, name)
+// releaseConnect 连接关闭后归还 loop 和连接数限制的名额
+func (this *Server) releaseConnect(c *connect.Connect) {
+	c.Loop().AddConnectCount(-1)
+	if this.limiter != nil {
+		this.limiter.release(peerIP(c.PeerAddr()))
 	}
 }
+
+func (this *Server) addConnect(id uint64, connect *connect.Connect) {
+	this.connects.add(id, connect)
+}
+
+func (this *Server) removeConnect(id uint64){
+	this.connects.remove(id)
+}
@@ -1,6 +1,10 @@
 package tcpserver
 
 import (
+	"github.com/zput/zput_net_golang/net/connect"
+	"github.com/zput/zput_net_golang/net/event_loop"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"golang.org/x/sys/unix"
 	"testing"
 )
 
@@ -11,22 +15,141 @@ func TestServerUnit(t *testing.T){
 	}
 
 	var loopTest = []struct{
-		in string
-		expect string
+		in uint64
+		expect uint64
 	}{
-		{"aaa", "aaa"},
-		{"bbb", "bbb"},
+		{1, 1},
+		{2, 2},
 	}
 
 	for _, tt := range loopTest{
 		testLoop.addConnect(tt.in, nil)
-		if _, ok := testLoop.connectPool[tt.in]; !ok{
+		if _, ok := testLoop.connects.Get(tt.in); !ok{
 			t.Fatal("expect exist, get not exist")
 		}
 
 		testLoop.removeConnect(tt.in)
-		if _, ok := testLoop.connectPool[tt.in]; ok{
+		if _, ok := testLoop.connects.Get(tt.in); ok{
 			t.Fatal("expect not exist, get exist")
 		}
 	}
 }
+
+func TestConnectRegistry(t *testing.T){
+	registry := NewConnectRegistry()
+	for _, id := range []uint64{1, 2, 3}{
+		registry.add(id, nil)
+	}
+	if registry.Len() != 3{
+		t.Fatalf("expect 3 connections, get %d", registry.Len())
+	}
+
+	var visited int
+	registry.Range(func(id uint64, c *connect.Connect) bool{
+		visited++
+		// 遍历的是快照, 可以在 fn 中删除
+		registry.remove(id)
+		return visited < 2
+	})
+	if visited != 2{
+		t.Fatalf("expect stop after 2 connections, get %d", visited)
+	}
+	if registry.Len() != 1{
+		t.Fatalf("expect 1 connection left, get %d", registry.Len())
+	}
+	if n := registry.Broadcast([]byte("skip nil")); n != 0{
+		t.Fatalf("expect broadcast to 0 connections, get %d", n)
+	}
+}
+
+func TestLoadBalancer(t *testing.T){
+	var loops []*event_loop.EventLoop
+	for i := 0; i < 3; i++{
+		loop, err := event_loop.New(i)
+		if err != nil{
+			t.Fatal(err)
+		}
+		loops = append(loops, loop)
+	}
+	newBalancer := func(lb protocol.LoadBalancing) LoadBalancer{
+		balancer := NewLoadBalancer(lb)
+		for _, loop := range loops{
+			balancer.Register(loop)
+		}
+		return balancer
+	}
+	client := func(ip byte, port int) unix.Sockaddr{
+		return &unix.SockaddrInet4{Addr: [4]byte{10, 0, 0, ip}, Port: port}
+	}
+
+	roundRobin := newBalancer(protocol.RoundRobin)
+	for i := 0; i < 6; i++{
+		if loop := roundRobin.Next(client(1, i)); loop != loops[i%3]{
+			t.Fatalf("round robin: expect loop %d, get %d", i%3, loop.SequenceID)
+		}
+	}
+
+	loops[0].AddConnectCount(2)
+	loops[1].AddConnectCount(1)
+	loops[2].AddConnectCount(3)
+	if loop := newBalancer(protocol.LeastConnections).Next(client(1, 1)); loop != loops[1]{
+		t.Fatalf("least connections: expect loop 1, get %d", loop.SequenceID)
+	}
+
+	// 同一个 IP 的不同端口分配到同一个 loop
+	sourceAddrHash := newBalancer(protocol.SourceAddrHash)
+	for ip := byte(1); ip < 10; ip++{
+		expect := sourceAddrHash.Next(client(ip, 1000))
+		for port := 1001; port < 1005; port++{
+			if loop := sourceAddrHash.Next(client(ip, port)); loop != expect{
+				t.Fatalf("source addr hash: expect loop %d, get %d", expect.SequenceID, loop.SequenceID)
+			}
+		}
+	}
+	// 没有 IP 的地址轮询
+	if sourceAddrHash.Next(&unix.SockaddrUnix{}) == sourceAddrHash.Next(&unix.SockaddrUnix{}){
+		t.Fatal("source addr hash: expect unix addresses round robin")
+	}
+}
+
+func TestConnectLimiter(t *testing.T){
+	limiter := newConnectLimiter(3, 2)
+	var full, below int
+	limiter.onFull = func(){ full++ }
+	limiter.onBelow = func(){ below++ }
+
+	var loopTest = []struct{
+		ip     string
+		expect error
+	}{
+		{"10.0.0.1", nil},
+		{"10.0.0.1", nil},
+		{"10.0.0.1", protocol.ErrTooManyConnectionsPerIP},
+		// unix socket 不限制单个 IP
+		{"", nil},
+		{"10.0.0.2", protocol.ErrTooManyConnections},
+	}
+	for _, tt := range loopTest{
+		if err := limiter.acquire(tt.ip); err != tt.expect{
+			t.Fatalf("ip[%s] expect %v, get %v", tt.ip, tt.expect, err)
+		}
+	}
+	if full != 1 || below != 0{
+		t.Fatalf("expect full once, get full %d below %d", full, below)
+	}
+
+	limiter.release("10.0.0.1")
+	if below != 1{
+		t.Fatalf("expect below once, get %d", below)
+	}
+	if err := limiter.acquire("10.0.0.1"); err != nil{
+		t.Fatalf("expect acquire after release, get %v", err)
+	}
+	if full != 2{
+		t.Fatalf("expect full twice, get %d", full)
+	}
+
+	if ip := sourceIP(&unix.SockaddrInet4{Addr: [4]byte{10, 0, 0, 1}, Port: 80}); ip != peerIP("10.0.0.1:80"){
+		t.Fatalf("expect source ip match peer addr, get %s", ip)
+	}
+}
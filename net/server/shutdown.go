@@ -0,0 +1,79 @@
+package tcpserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/zput/zput_net_golang/net/log"
+)
+
+// shutdownPollInterval Shutdown 检查连接是否全部关闭的间隔
+const shutdownPollInterval = 10 * time.Millisecond
+
+// ShutdownReport Shutdown 的结果
+type ShutdownReport struct {
+	// Drained 截止时间之前写完数据正常关闭的连接数
+	Drained int
+	// Killed 截止时间到达时仍未关闭, 被强制关闭的连接数
+	Killed int
+}
+
+// Shutdown 优雅关闭: 停止接收新连接, 等待每个连接处理完交给 worker 的帧、写完发送缓冲区后关闭
+// (或者配置了 HalfCloseOnShutdown 时只关闭写端, 等对端关闭),
+// ctx 结束时强制关闭剩下的连接, 最后停止所有 loop。
+func (this *Server) Shutdown(ctx context.Context) (ShutdownReport, error) {
+	var report ShutdownReport
+	if this.stopped.Set(true) {
+		return report, nil
+	}
+
+	this.closeAcceptors()
+
+	connects := this.connects.snapshot()
+	for k, v := range connects {
+		var err error
+		if this.options.GetHalfCloseOnShutdown() {
+			err = v.ShutdownWrite()
+		} else {
+			err = v.CloseGracefully()
+		}
+		if err != nil {
+			this.logger.Error("shutdown connection failed", log.ConnID(k), log.Err(err))
+		}
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	var ctxErr error
+wait:
+	for this.connects.Len() > 0 {
+		select {
+		case <-ctx.Done():
+			ctxErr = ctx.Err()
+			break wait
+		case <-ticker.C:
+		}
+	}
+
+	remaining := this.connects.snapshot()
+	for k, v := range remaining {
+		if err := v.Close(); err != nil {
+			this.logger.Error("close connection failed", log.ConnID(k), log.Err(err))
+		}
+	}
+	report.Killed = len(remaining)
+	report.Drained = len(connects) - report.Killed
+	if report.Drained < 0 {
+		report.Drained = 0
+	}
+
+	this.timingWheel.Stop()
+	this.stopLoops()
+	this.stopWorkerPool()
+
+	if report.Killed > 0 {
+		return report, ctxErr
+	}
+	return report, nil
+}
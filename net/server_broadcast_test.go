@@ -0,0 +1,79 @@
+package net
+
+import (
+	"github.com/zput/zput_net_golang/net/connect"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/server"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerBroadcast(t *testing.T) {
+	var (
+		news    = "broadcast news"
+		clients = 3
+	)
+	s, err := tcpserver.New(new(tcpserver.HandleEventImpl),
+		protocol.Address(":51841"),
+		protocol.NumLoops(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	conns := make([]net.Conn, 0, clients)
+	for i := 0; i < clients; i++ {
+		conn, err := net.DialTimeout("tcp", "127.0.0.1:51841", time.Second*5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	for s.Connects().Len() != clients {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect %d connections, get %d", clients, s.Connects().Len())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	var total int64
+	for _, n := range s.LoopConnectCounts() {
+		total += n
+	}
+	if total != int64(clients) {
+		t.Fatalf("expect %d connections in loops, get %v", clients, s.LoopConnectCounts())
+	}
+
+	// 同一个客户端地址的多个连接也不会互相覆盖
+	ids := make(map[uint64]bool)
+	s.Connects().Range(func(id uint64, c *connect.Connect) bool {
+		if c.ID() != id || ids[id] {
+			t.Fatalf("unexpected connection id %d", id)
+		}
+		ids[id] = true
+		if got, ok := s.Connects().Get(id); !ok || got != c {
+			t.Fatalf("expect connection %d exist", id)
+		}
+		return true
+	})
+
+	if n := s.Connects().Broadcast([]byte(news)); n != clients {
+		t.Fatalf("expect broadcast to %d connections, get %d", clients, n)
+	}
+
+	buf := make([]byte, 64)
+	for _, conn := range conns {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("read error[%v]", err)
+		}
+		if string(buf[:n]) != news {
+			t.Fatalf("expect %s, get %s", news, string(buf[:n]))
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package net
+
+import (
+	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/server"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEdgeTriggeredEcho(t *testing.T) {
+	s, err := tcpserver.New(new(exampleRW),
+		protocol.Address(":51845"),
+		protocol.NumLoops(2),
+		protocol.EdgeTriggered(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:51845", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 远大于一次 read 的 64KiB 和 socket 缓冲区, 边沿触发下读写都要进行到 EAGAIN 才不会卡住
+	send := make([]byte, 4*1024*1024)
+	for i := range send {
+		send[i] = byte(i % 251)
+	}
+	go func() {
+		_, _ = conn.Write(send)
+	}()
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 10))
+	receive := make([]byte, len(send))
+	if _, err = io.ReadFull(conn, receive); err != nil {
+		t.Fatalf("read error[%v]", err)
+	}
+	if string(receive) != string(send) {
+		t.Fatal("echo data mismatch")
+	}
+}
@@ -0,0 +1,195 @@
+package net
+
+import (
+	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/server"
+	"golang.org/x/sys/unix"
+	"net"
+	"testing"
+	"time"
+)
+
+// echoOnce 发送并等待回显
+func echoOnce(conn net.Conn, data string) error {
+	if _, err := conn.Write([]byte(data)); err != nil {
+		return err
+	}
+	buf := make([]byte, len(data))
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, err := conn.Read(buf)
+	return err
+}
+
+func TestConnectionLimitReject(t *testing.T) {
+	rejected := make(chan error, 1)
+	s, err := tcpserver.New(new(exampleRW),
+		protocol.Address(":51847"),
+		protocol.NumLoops(1),
+		protocol.MaxConnections(2),
+		protocol.OnConnectRejected(func(sa unix.Sockaddr, err error) {
+			rejected <- err
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.DialTimeout("tcp", "127.0.0.1:51847", time.Second*5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if err = echoOnce(conn, "limit"); err != nil {
+			t.Fatalf("read error[%v]", err)
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:51847", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case err := <-rejected:
+		if err != protocol.ErrTooManyConnections {
+			t.Fatalf("expect ErrTooManyConnections, get %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait reject timeout")
+	}
+	if err = echoOnce(conn, "limit"); err == nil {
+		t.Fatal("expect rejected connection closed")
+	}
+	if s.Rejected() != 1 {
+		t.Fatalf("expect 1 rejected, get %d", s.Rejected())
+	}
+}
+
+func TestConnectionLimitPauseAccept(t *testing.T) {
+	s, err := tcpserver.New(new(exampleRW),
+		protocol.Address(":51848"),
+		protocol.NumLoops(1),
+		protocol.MaxConnections(1),
+		protocol.ConnectionLimitPolicy(protocol.LimitPauseAccept))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	first, err := net.DialTimeout("tcp", "127.0.0.1:51848", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = echoOnce(first, "first"); err != nil {
+		t.Fatalf("read error[%v]", err)
+	}
+
+	// 暂停监听时连接留在 backlog 中, 不会被关闭也不会被处理
+	second, err := net.DialTimeout("tcp", "127.0.0.1:51848", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if _, err = second.Write([]byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	_ = second.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	if _, err = second.Read(make([]byte, 6)); err == nil {
+		t.Fatal("expect second connection waiting in backlog")
+	}
+
+	// 第一个连接关闭后恢复 accept
+	_ = first.Close()
+	buf := make([]byte, 6)
+	_ = second.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err = second.Read(buf); err != nil || string(buf) != "second" {
+		t.Fatalf("expect second echo, get %s error[%v]", string(buf), err)
+	}
+	if s.Rejected() != 0 {
+		t.Fatalf("expect 0 rejected, get %d", s.Rejected())
+	}
+}
+
+func TestConnectionLimitPauseAcceptBatch(t *testing.T) {
+	s, err := tcpserver.New(new(exampleRW),
+		protocol.Address(":51859"),
+		protocol.NumLoops(1),
+		protocol.MaxConnections(1),
+		protocol.ConnectionLimitPolicy(protocol.LimitPauseAccept))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	// Start 之前已经监听, 几个连接同时排在 backlog 中, 一次可读事件里 accept
+	var conns []net.Conn
+	for i := 0; i < 4; i++ {
+		conn, err := net.DialTimeout("tcp", "127.0.0.1:51859", time.Second*5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	go s.Start()
+
+	if err = echoOnce(conns[0], "first"); err != nil {
+		t.Fatalf("read error[%v]", err)
+	}
+	// 达到上限之后同一批的连接留在 backlog 中, 不能被 accept 之后关闭
+	time.Sleep(time.Millisecond * 100)
+	if s.Rejected() != 0 {
+		t.Fatalf("expect 0 rejected, get %d", s.Rejected())
+	}
+
+	_ = conns[0].Close()
+	if err = echoOnce(conns[1], "second"); err != nil {
+		t.Fatalf("expect second echo, error[%v]", err)
+	}
+	if s.Rejected() != 0 {
+		t.Fatalf("expect 0 rejected, get %d", s.Rejected())
+	}
+}
+
+func TestAcceptFilter(t *testing.T) {
+	filter, err := protocol.NewCIDRFilter(nil, []string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected := make(chan error, 1)
+	s, err := tcpserver.New(new(exampleRW),
+		protocol.Address(":51849"),
+		protocol.NumLoops(1),
+		protocol.OnAccept(filter.Accept),
+		protocol.OnConnectRejected(func(sa unix.Sockaddr, err error) {
+			rejected <- err
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:51849", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case err := <-rejected:
+		if err != protocol.ErrConnectionDenied {
+			t.Fatalf("expect ErrConnectionDenied, get %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait reject timeout")
+	}
+	if err = echoOnce(conn, "filter"); err == nil {
+		t.Fatal("expect denied connection closed")
+	}
+	if s.Connects().Len() != 0 {
+		t.Fatalf("expect no connection created, get %d", s.Connects().Len())
+	}
+}
@@ -0,0 +1,71 @@
+package net
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zput/zput_net_golang/net/metrics"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/server"
+)
+
+func TestServerMetrics(t *testing.T) {
+	registry := metrics.NewRegistry("")
+	s, err := tcpserver.New(new(exampleRW),
+		protocol.Address(":51850"),
+		protocol.NumLoops(2),
+		protocol.Metrics(registry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:51850", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = echoOnce(conn, "metrics"); err != nil {
+		t.Fatalf("read error[%v]", err)
+	}
+	_ = conn.Close()
+
+	httpServer := httptest.NewServer(registry.Handler())
+	defer httpServer.Close()
+
+	var out string
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		resp, err := httpServer.Client().Get(httpServer.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		out = string(body)
+		// 关闭是异步的, 等待 closed 计数
+		if strings.Contains(out, "zput_connections_closed_total 1\n") || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	for _, line := range []string{
+		"zput_connections_accepted_total 1\n",
+		"zput_connections_closed_total 1\n",
+		"zput_connections_active 0\n",
+		"zput_bytes_read_total 7\n",
+		"zput_bytes_written_total 7\n",
+		`zput_frames_decoded_total{codec="*protocol.BuiltInFrameCodec"} 1` + "\n",
+		`zput_loop_pending_tasks{loop="1"}`,
+		`zput_loop_iteration_seconds_count{loop="-1"}`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Fatalf("expect %q in:\n%s", line, out)
+		}
+	}
+}
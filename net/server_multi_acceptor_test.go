@@ -0,0 +1,52 @@
+package net
+
+import (
+	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/server"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMultiAcceptor(t *testing.T) {
+	var clients = 20
+	s, err := tcpserver.New(new(exampleRW),
+		protocol.Address(":51846"),
+		protocol.NumLoops(4),
+		protocol.MultiAcceptor(true),
+		protocol.EdgeTriggered(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	for i := 0; i < clients; i++ {
+		conn, err := net.DialTimeout("tcp", "127.0.0.1:51846", time.Second*5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		if _, err = conn.Write([]byte("multi acceptor")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 20)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("read error[%v]", err)
+		}
+		if string(buf[:n]) != "multi acceptor" {
+			t.Fatalf("expect multi acceptor, get %s", string(buf[:n]))
+		}
+	}
+
+	var total int64
+	for _, n := range s.LoopConnectCounts() {
+		total += n
+	}
+	if total != int64(clients) || s.Connects().Len() != clients {
+		t.Fatalf("expect %d connections, get %v registry %d", clients, s.LoopConnectCounts(), s.Connects().Len())
+	}
+}
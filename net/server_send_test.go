@@ -0,0 +1,74 @@
+package net
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zput/zput_net_golang/net/connect"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/server"
+)
+
+// exampleAsyncReply 在另外的协程中回复, 第一条用 SendWithCallback, 第二条 SendAndClose
+type exampleAsyncReply struct {
+	tcpserver.HandleEventImpl
+	sent chan error
+}
+
+func (this *exampleAsyncReply) MessageCallback(c *connect.Connect, buf []byte) []byte {
+	msg := string(buf)
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		if err := c.SendWithCallback([]byte("re:"+msg), func(c *connect.Connect, err error) {
+			this.sent <- err
+		}); err != nil {
+			this.sent <- err
+			return
+		}
+		_ = c.SendAndClose([]byte("bye"))
+	}()
+	return nil
+}
+
+func TestServerAsyncSend(t *testing.T) {
+	handler := &exampleAsyncReply{sent: make(chan error, 1)}
+	s, err := tcpserver.New(handler,
+		protocol.Address(":51853"),
+		protocol.NumLoops(1),
+		protocol.CodeImp(new(protocol.LineBasedFrameCodec)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:51853", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 回复经过 codec 编码, 写完之后服务端关闭连接
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	got, err := ioutil.ReadAll(conn)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if string(got) != "re:ping\nbye\n" {
+		t.Fatalf("expect framed replies, get %q", got)
+	}
+	select {
+	case err := <-handler.sent:
+		if err != nil {
+			t.Fatalf("expect send completed, get %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("expect send callback")
+	}
+}
@@ -0,0 +1,82 @@
+package net
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/zput/zput_net_golang/net/connect"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/server"
+)
+
+// exampleSendFile 收到任意请求后用 sendfile 回复整个文件
+type exampleSendFile struct {
+	tcpserver.HandleEventImpl
+	file      *os.File
+	size      int64
+	completed chan struct{}
+}
+
+func (this *exampleSendFile) MessageCallback(c *connect.Connect, buf []byte) []byte {
+	if err := c.SendFile(this.file, 0, this.size); err != nil {
+		_ = c.Close()
+	}
+	return nil
+}
+
+func (this *exampleSendFile) WriteCompletCallback(c *connect.Connect) {
+	this.completed <- struct{}{}
+}
+
+func TestServerSendFile(t *testing.T) {
+	content := make([]byte, 8*1024*1024)
+	rand.Read(content)
+	f, err := ioutil.TempFile("", "sendfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err = f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &exampleSendFile{file: f, size: int64(len(content)), completed: make(chan struct{}, 1)}
+	s, err := tcpserver.New(handler,
+		protocol.Address(":51851"),
+		protocol.NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:51851", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("get")); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 10))
+	got := make([]byte, len(content))
+	if _, err = io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("received file does not match")
+	}
+	select {
+	case <-handler.completed:
+	case <-time.After(time.Second * 5):
+		t.Fatal("expect write complete callback")
+	}
+}
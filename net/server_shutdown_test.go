@@ -0,0 +1,166 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"github.com/zput/zput_net_golang/net/connect"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/server"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+type exampleShutdown struct {
+	tcpserver.HandleEventImpl
+	reply []byte
+}
+
+func (this *exampleShutdown) MessageCallback(c *connect.Connect, buf []byte) []byte {
+	return this.reply
+}
+
+func TestServerShutdownDrain(t *testing.T) {
+	handler := &exampleShutdown{reply: bytes.Repeat([]byte("z"), 1<<20)}
+
+	s, err := tcpserver.New(handler,
+		protocol.Address(":51839"),
+		protocol.NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:51839", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	// 给服务端时间处理请求, 客户端还没有开始读, 回复会堆积在发送缓冲区
+	time.Sleep(time.Millisecond * 200)
+
+	type result struct {
+		report tcpserver.ShutdownReport
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		report, err := s.Shutdown(ctx)
+		done <- result{report, err}
+	}()
+
+	got, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read error[%v]", err)
+	}
+	if len(got) != len(handler.reply) {
+		t.Fatalf("expect %d bytes before close, get %d", len(handler.reply), len(got))
+	}
+	_ = conn.Close()
+
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("shutdown error[%v]", r.err)
+	}
+	if r.report.Drained != 1 || r.report.Killed != 0 {
+		t.Fatalf("expect drained 1 killed 0, get %+v", r.report)
+	}
+}
+
+func TestServerShutdownKill(t *testing.T) {
+	s, err := tcpserver.New(new(exampleShutdown),
+		protocol.Address(":51840"),
+		protocol.NumLoops(1),
+		protocol.HalfCloseOnShutdown(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:51840", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(time.Millisecond * 200)
+
+	// 只关闭了写端, 客户端一直不关闭, 截止时间到了之后被强制关闭
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancel()
+	report, err := s.Shutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expect deadline exceeded, get %v", err)
+	}
+	if report.Drained != 0 || report.Killed != 1 {
+		t.Fatalf("expect drained 0 killed 1, get %+v", report)
+	}
+}
+
+// exampleSlowWorker 在 worker 中慢慢处理, 关闭连接时回调可能还在执行
+type exampleSlowWorker struct {
+	tcpserver.HandleEventImpl
+	delay  time.Duration
+	closed chan bool
+	busy   protocol.Bool
+}
+
+func (this *exampleSlowWorker) MessageCallback(c *connect.Connect, buf []byte) []byte {
+	this.busy.Set(true)
+	defer this.busy.Set(false)
+	time.Sleep(this.delay)
+	return buf
+}
+
+func (this *exampleSlowWorker) ConnectCloseCallback(c *connect.Connect) {
+	this.closed <- this.busy.Get()
+}
+
+func TestServerShutdownWorkerPool(t *testing.T) {
+	handler := &exampleSlowWorker{delay: time.Millisecond * 300, closed: make(chan bool, 1)}
+	s, err := tcpserver.New(handler,
+		protocol.Address(":51860"),
+		protocol.NumLoops(1),
+		protocol.WorkerPool(1, 16, protocol.WorkerRejectClose))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:51860", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("slow")); err != nil {
+		t.Fatal(err)
+	}
+	// 帧已经交给 worker, 回调还在执行, 发送缓冲区是空的
+	time.Sleep(time.Millisecond * 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	report, err := s.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("shutdown error[%v]", err)
+	}
+	if report.Drained != 1 || report.Killed != 0 {
+		t.Fatalf("expect drained 1 killed 0, get %+v", report)
+	}
+	if busy := <-handler.closed; busy {
+		t.Fatal("expect ConnectCloseCallback after MessageCallback returned")
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	got, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read error[%v]", err)
+	}
+	if string(got) != "slow" {
+		t.Fatalf("expect reply slow before close, get %q", got)
+	}
+}
@@ -0,0 +1,204 @@
+package net
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/zput/zput_net_golang/net/connect"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/server"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+type exampleTLS struct {
+	exampleRW
+	states chan tls.ConnectionState
+}
+
+func (this *exampleTLS) ConnectCallback(c *connect.Connect) {
+	this.states <- *c.TLSState()
+}
+
+// newTestCertificate 生成自签名证书
+func newTestCertificate(t *testing.T, host string) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, leaf
+}
+
+func TestTLSServer(t *testing.T) {
+	certA, _ := newTestCertificate(t, "a.test")
+	certB, leafB := newTestCertificate(t, "b.test")
+
+	handler := &exampleTLS{states: make(chan tls.ConnectionState, 1)}
+	s, err := tcpserver.New(handler,
+		protocol.Address(":51843"),
+		protocol.NumLoops(1),
+		protocol.TLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{certA, certB},
+			NextProtos:   []string{"h2", "echo"},
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(leafB)
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second * 5}, "tcp", "127.0.0.1:51843", &tls.Config{
+		ServerName: "b.test",
+		RootCAs:    roots,
+		NextProtos: []string{"echo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// SNI 选中了 b.test 的证书
+	if cn := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; cn != "b.test" {
+		t.Fatalf("expect certificate b.test, get %s", cn)
+	}
+
+	select {
+	case state := <-handler.states:
+		if state.ServerName != "b.test" || state.NegotiatedProtocol != "echo" {
+			t.Fatalf("unexpected tls state server name[%s] protocol[%s]", state.ServerName, state.NegotiatedProtocol)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait tls connect callback timeout")
+	}
+
+	// 超过一个 TLS 记录的数据, 经过 outBuffer 发送
+	send := make([]byte, 64*1024)
+	for i := range send {
+		send[i] = byte(i)
+	}
+	go func() {
+		_, _ = conn.Write(send)
+	}()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	receive := make([]byte, len(send))
+	if _, err = io.ReadFull(conn, receive); err != nil {
+		t.Fatalf("read error[%v]", err)
+	}
+	if string(receive) != string(send) {
+		t.Fatal("echo data mismatch")
+	}
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	cert, _ := newTestCertificate(t, "a.test")
+	s, err := tcpserver.New(new(tcpserver.HandleEventImpl),
+		protocol.Address(":51844"),
+		protocol.NumLoops(1),
+		protocol.TLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}),
+		protocol.TLSHandshakeTimeout(time.Millisecond*200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:51844", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 不发送 ClientHello, 服务端超时后关闭连接
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expect EOF, get %v", err)
+	}
+}
+
+func TestTLSSendAndCloseDuringHandshake(t *testing.T) {
+	cert, leaf := newTestCertificate(t, "a.test")
+	s, err := tcpserver.New(new(tcpserver.HandleEventImpl),
+		protocol.Address(":51861"),
+		protocol.NumLoops(1),
+		protocol.TLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	raw, err := net.DialTimeout("tcp", "127.0.0.1:51861", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	for i := 0; s.Connects().Len() == 0; i++ {
+		if i > 500 {
+			t.Fatal("wait connection timeout")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// 还没有发送 ClientHello, 最后的消息暂存到握手完成之后写出, 写完再关闭
+	sent := make(chan error, 1)
+	s.Connects().Range(func(id uint64, c *connect.Connect) bool {
+		if err := c.SendWithCallback([]byte("hello "), func(c *connect.Connect, err error) {
+			sent <- err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.SendAndClose([]byte("bye")); err != nil {
+			t.Fatal(err)
+		}
+		return true
+	})
+	time.Sleep(time.Millisecond * 50)
+	select {
+	case err := <-sent:
+		t.Fatalf("expect no send callback before handshake, get %v", err)
+	default:
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	conn := tls.Client(raw, &tls.Config{ServerName: "a.test", RootCAs: roots})
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 5))
+	got, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read error[%v]", err)
+	}
+	if string(got) != "hello bye" {
+		t.Fatalf("expect hello bye before close, get %q", got)
+	}
+	if err = <-sent; err != nil {
+		t.Fatalf("expect send completed, get %v", err)
+	}
+}
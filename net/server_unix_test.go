@@ -0,0 +1,98 @@
+package net
+
+import (
+	"github.com/zput/zput_net_golang/net/client"
+	"github.com/zput/zput_net_golang/net/connect"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/server"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+type exampleUnix struct {
+	exampleRW
+	creds chan *connect.PeerCredential
+}
+
+func (this *exampleUnix) ConnectCallback(c *connect.Connect) {
+	cred, err := c.PeerCred()
+	if err != nil {
+		cred = nil
+	}
+	this.creds <- cred
+}
+
+func TestUnixServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zput_net_unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sockFile := filepath.Join(dir, "echo.sock")
+
+	handler := &exampleUnix{creds: make(chan *connect.PeerCredential, 2)}
+	s, err := tcpserver.New(handler,
+		protocol.Network("unix"),
+		protocol.Address(sockFile),
+		protocol.NumLoops(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+
+	conn, err := net.DialTimeout("unix", sockFile, time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cred := <-handler.creds
+	if runtime.GOOS == "linux" {
+		if cred == nil || cred.Pid != os.Getpid() {
+			t.Fatalf("expect peer pid %d, get %+v", os.Getpid(), cred)
+		}
+	}
+
+	if _, err = conn.Write([]byte("unix echo")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 20)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read error[%v]", err)
+	}
+	if string(buf[:n]) != "unix echo" {
+		t.Fatalf("expect unix echo, get %s", string(buf[:n]))
+	}
+
+	// tcpclient 也可以通过 unix socket 连接
+	clientHandler := &exampleClient{send: "unix client", receive: make(chan string, 1)}
+	c, err := tcpclient.New(clientHandler, s.SubLoops(), s.TimingWheel(),
+		protocol.Network("unix"),
+		protocol.Address(sockFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-clientHandler.receive:
+		if got != clientHandler.send {
+			t.Fatalf("expect %s, get %s", clientHandler.send, got)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait unix client echo timeout")
+	}
+
+	s.Stop()
+	if _, err := os.Stat(sockFile); !os.IsNotExist(err) {
+		t.Fatalf("expect socket file removed, get %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package net
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zput/zput_net_golang/net/connect"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/server"
+)
+
+// exampleBlocking 收到 slow 时阻塞到 release 关闭, 其它数据直接回显
+type exampleBlocking struct {
+	tcpserver.HandleEventImpl
+	release chan struct{}
+}
+
+func (this *exampleBlocking) MessageCallback(c *connect.Connect, buf []byte) []byte {
+	if string(buf) == "slow" {
+		<-this.release
+	}
+	out := make([]byte, len(buf))
+	copy(out, buf)
+	return out
+}
+
+func TestServerWorkerPool(t *testing.T) {
+	handler := &exampleBlocking{release: make(chan struct{})}
+	s, err := tcpserver.New(handler,
+		protocol.Address(":51852"),
+		protocol.NumLoops(1),
+		protocol.WorkerPool(2, 16, protocol.WorkerRejectClose))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	slow, err := net.DialTimeout("tcp", "127.0.0.1:51852", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	if _, err = slow.Write([]byte("slow")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一个 loop 上的另一个连接不受阻塞的回调影响
+	fast, err := net.DialTimeout("tcp", "127.0.0.1:51852", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fast.Close()
+	if err = echoOnce(fast, "fast"); err != nil {
+		t.Fatalf("read error[%v]", err)
+	}
+
+	close(handler.release)
+	buf := make([]byte, 4)
+	_ = slow.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err = slow.Read(buf); err != nil || string(buf) != "slow" {
+		t.Fatalf("expect slow, get %q %v", buf, err)
+	}
+}
@@ -0,0 +1,102 @@
+package net
+
+import (
+	"fmt"
+	"github.com/zput/zput_net_golang/net/protocol"
+	"github.com/zput/zput_net_golang/net/udpserver"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+type exampleUDP struct{}
+
+func (this *exampleUDP) PacketCallback(c *udpserver.PacketConn, addr net.Addr, data []byte) {
+	if err := c.WriteTo(data, addr); err != nil {
+		panic(err)
+	}
+}
+
+func TestUDPServer(t *testing.T) {
+	s, err := udpserver.New(new(exampleUDP),
+		protocol.Address(":51842"),
+		protocol.NumLoops(2),
+		protocol.ReusePort(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	defer s.Stop()
+
+	if len(s.PacketConns()) != 2 {
+		t.Fatalf("expect 2 sockets with reuse port, get %d", len(s.PacketConns()))
+	}
+
+	// 多个客户端地址, 会被分到不同的 socket 上
+	for i := 0; i < 4; i++ {
+		conn, err := net.Dial("udp", "127.0.0.1:51842")
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := fmt.Sprintf("datagram %d", i)
+		if _, err = conn.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 64)
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("read error[%v]", err)
+		}
+		if string(buf[:n]) != msg {
+			t.Fatalf("expect %s, get %s", msg, string(buf[:n]))
+		}
+		_ = conn.Close()
+	}
+}
+
+func countOpenFds(t *testing.T) int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("need /proc/self/fd")
+	}
+	return len(fds)
+}
+
+func TestUDPServerNewFailure(t *testing.T) {
+	busy, err := net.ListenPacket("udp", "127.0.0.1:51856")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	// 地址被占用时 New 失败, 已经创建的 loop 和 socket 都要释放
+	before := countOpenFds(t)
+	if _, err = udpserver.New(new(exampleUDP), protocol.Address("127.0.0.1:51856")); err == nil {
+		t.Fatal("expect address in use")
+	}
+	if after := countOpenFds(t); after != before {
+		t.Fatalf("expect %d open fds, get %d", before, after)
+	}
+}
+
+func TestUDPServerWriteAfterStop(t *testing.T) {
+	s, err := udpserver.New(new(exampleUDP), protocol.Address("127.0.0.1:51857"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	time.Sleep(time.Millisecond * 50)
+
+	c := s.PacketConns()[0]
+	peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 51858}
+	if err = c.WriteTo([]byte("before stop"), peer); err != nil {
+		t.Fatal(err)
+	}
+	// Stop 之后 fd 已经关闭, 不能再写到可能被复用的 fd 上
+	s.Stop()
+	if err = c.WriteTo([]byte("after stop"), peer); err != udpserver.ErrPacketConnClosed {
+		t.Fatalf("expect ErrPacketConnClosed, get %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package udpserver
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/zput/zput_net_golang/net/event_loop"
+	"github.com/zput/zput_net_golang/net/log"
+	"github.com/zput/zput_net_golang/net/protocol"
+
+	reuseport "github.com/libp2p/go-reuseport"
+	"golang.org/x/sys/unix"
+)
+
+// maxPacketSize UDP 报文最大长度
+const maxPacketSize = 0xFFFF
+
+// readBudget 每次可读事件最多处理的报文数, 避免一个 socket 占满 loop
+const readBudget = 64
+
+var errUnsupportedAddr = errors.New("unsupported udp address")
+
+// ErrPacketConnClosed Server.Stop 之后调用 WriteTo
+var ErrPacketConnClosed = errors.New("packet conn closed")
+
+// defaultLogger 没有调用 SetLogger 时使用
+var defaultLogger = log.Default()
+
+// OnPacketCallback 收到一个报文; data 只在回调期间有效
+type OnPacketCallback func(c *PacketConn, addr net.Addr, data []byte)
+
+// PacketConn 一个 UDP socket, 由一个 loop 负责读
+type PacketConn struct {
+	loop  *event_loop.EventLoop
+	event *event_loop.Event
+	conn  net.PacketConn
+	// File() 得到的 fd 副本
+	file *os.File
+	fd   int
+	buf  []byte
+	// 监听 [::] 时是 AF_INET6, 回复 IPv4 对端要用 v4-mapped 地址
+	isInet6 bool
+	// WriteTo 在任意协程调用, 持读锁使用 fd; close 持写锁关闭 fd, 避免写到被复用的 fd 上
+	mutex  sync.RWMutex
+	closed bool
+
+	packetCallback OnPacketCallback
+	logger         *log.FieldLogger
+}
+
+func newPacketConn(option protocol.NetWorkAndAddressAndOption, loop *event_loop.EventLoop) (*PacketConn, error) {
+	var (
+		conn net.PacketConn
+		err  error
+	)
+	if option.ReusePort {
+		conn, err = reuseport.ListenPacket(option.Network, option.Address)
+	} else {
+		conn, err = net.ListenPacket(option.Network, option.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		_ = conn.Close()
+		return nil, errors.New("could not get file descriptor")
+	}
+	file, err := udpConn.File()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	var packetConn = PacketConn{
+		loop: loop,
+		conn: conn,
+		file: file,
+		fd:   int(file.Fd()),
+		buf:  make([]byte, maxPacketSize),
+	}
+	packetConn.SetLogger(defaultLogger)
+	// file.Fd() 会把 fd 设置为阻塞模式
+	if err = unix.SetNonblock(packetConn.fd, true); err != nil {
+		_ = packetConn.closeFd()
+		return nil, err
+	}
+
+	if sa, err := unix.Getsockname(packetConn.fd); err == nil {
+		_, packetConn.isInet6 = sa.(*unix.SockaddrInet6)
+	}
+
+	packetConn.event = event_loop.NewEvent(loop, packetConn.fd)
+	packetConn.event.SetReadFunc(packetConn.readEvent)
+	if err = packetConn.event.Register(); err != nil {
+		_ = packetConn.closeFd()
+		return nil, err
+	}
+	return &packetConn, nil
+}
+
+// SetLogger 使用 Server 的 logger, 附加上 socket 的字段
+func (this *PacketConn) SetLogger(logger *log.FieldLogger) {
+	this.logger = logger.With(log.Fd(this.fd), log.F("listen", this.conn.LocalAddr().String()), log.Loop(this.loop.SequenceID))
+}
+
+func (this *PacketConn) listen() error {
+	return this.event.EnableReading(true)
+}
+
+// LocalAddr 本地监听地址
+func (this *PacketConn) LocalAddr() net.Addr {
+	return this.conn.LocalAddr()
+}
+
+// WriteTo 发送一个报文, 可以在任意协程调用; socket 发送缓冲区满时直接返回错误, 关闭之后返回 ErrPacketConnClosed
+func (this *PacketConn) WriteTo(data []byte, addr net.Addr) error {
+	sa, err := udpAddrToSockaddr(addr, this.isInet6)
+	if err != nil {
+		return err
+	}
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	if this.closed {
+		return ErrPacketConnClosed
+	}
+	return unix.Sendto(this.fd, data, 0, sa)
+}
+
+func (this *PacketConn) readEvent() {
+	for i := 0; i < readBudget; i++ {
+		n, sa, err := unix.Recvfrom(this.fd, this.buf, 0)
+		if err != nil {
+			if err != unix.EAGAIN && err != unix.EINTR {
+				this.logger.Error("recvfrom failed", log.Err(err))
+			}
+			return
+		}
+		this.packetCallback(this, sockaddrToUDPAddr(sa), this.buf[:n])
+	}
+}
+
+func (this *PacketConn) close() {
+	// 先拒绝新的 WriteTo, fd 在 loop 中摘除 event 之后再关闭
+	this.mutex.Lock()
+	this.closed = true
+	this.mutex.Unlock()
+
+	this.loop.RunInLoop(func() {
+		if err := this.event.DisableAll(); err != nil {
+			this.logger.Error("disable packet conn event failed", log.Err(err))
+		}
+		if err := this.event.UnRegister(); err != nil {
+			this.logger.Error("unregister packet conn event failed", log.Err(err))
+		}
+		this.mutex.Lock()
+		defer this.mutex.Unlock()
+		if err := this.closeFd(); err != nil {
+			this.logger.Error("close packet conn failed", log.Err(err))
+		}
+	})
+}
+
+func (this *PacketConn) closeFd() error {
+	_ = this.conn.Close()
+	return this.file.Close()
+}
+
+func sockaddrToUDPAddr(sa unix.Sockaddr) *net.UDPAddr {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, sa.Addr[:])
+		return &net.UDPAddr{IP: ip, Port: sa.Port}
+	case *unix.SockaddrInet6:
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, sa.Addr[:])
+		var zone string
+		if sa.ZoneId != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa.ZoneId)); err == nil {
+				zone = ifi.Name
+			}
+		}
+		return &net.UDPAddr{IP: ip, Port: sa.Port, Zone: zone}
+	default:
+		return &net.UDPAddr{}
+	}
+}
+
+func udpAddrToSockaddr(addr net.Addr, isInet6 bool) (unix.Sockaddr, error) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil, errUnsupportedAddr
+	}
+	if ip4 := udpAddr.IP.To4(); ip4 != nil && !isInet6 {
+		sa := &unix.SockaddrInet4{Port: udpAddr.Port}
+		copy(sa.Addr[:], ip4)
+		return sa, nil
+	}
+	if ip6 := udpAddr.IP.To16(); ip6 != nil {
+		sa := &unix.SockaddrInet6{Port: udpAddr.Port}
+		copy(sa.Addr[:], ip6)
+		if udpAddr.Zone != "" {
+			if ifi, err := net.InterfaceByName(udpAddr.Zone); err == nil {
+				sa.ZoneId = uint32(ifi.Index)
+			}
+		}
+		return sa, nil
+	}
+	return nil, errUnsupportedAddr
+}
@@ -0,0 +1,109 @@
+package udpserver
+
+import (
+	"net"
+	"runtime"
+
+	"github.com/zput/zput_net_golang/net/event_loop"
+	"github.com/zput/zput_net_golang/net/log"
+	"github.com/zput/zput_net_golang/net/protocol"
+)
+
+type IHandleEvent interface {
+	PacketCallback(c *PacketConn, addr net.Addr, data []byte)
+}
+
+// Server UDP 服务; 开启 ReusePort 时每个 loop 各自绑定一个 socket, 由内核按四元组分流,
+// 否则只有一个 socket 和一个 loop
+type Server struct {
+	options     *protocol.Options
+	handleEvent IHandleEvent
+	loops       []*event_loop.EventLoop
+	packetConns []*PacketConn
+	stopped     protocol.Bool
+	logger      *log.FieldLogger
+}
+
+func New(handleEvent IHandleEvent, opts ...protocol.Option) (*Server, error) {
+	// 默认 udp, 用户的 Network 选项可以覆盖成 udp4/udp6
+	options := protocol.NewOptions(append([]protocol.Option{protocol.Network("udp")}, opts...)...)
+
+	var udpServer = Server{
+		options:     options,
+		handleEvent: handleEvent,
+		logger:      options.GetLogger(),
+	}
+
+	if options.NumLoops <= 0 {
+		options.NumLoops = runtime.NumCPU()
+	}
+	if !options.GetNet().ReusePort {
+		options.NumLoops = 1
+	}
+
+	for i := 0; i < options.NumLoops; i++ {
+		loop, err := event_loop.New(i)
+		if err != nil {
+			udpServer.release()
+			return nil, err
+		}
+		udpServer.loops = append(udpServer.loops, loop)
+
+		packetConn, err := newPacketConn(options.GetNet(), loop)
+		if err != nil {
+			udpServer.logger.Error("new packet conn failed", log.F("listen", options.GetNet().Address), log.Loop(i), log.Err(err))
+			udpServer.release()
+			return nil, err
+		}
+		packetConn.SetLogger(udpServer.logger)
+		packetConn.packetCallback = handleEvent.PacketCallback
+		udpServer.packetConns = append(udpServer.packetConns, packetConn)
+	}
+
+	return &udpServer, nil
+}
+
+// Start 启动 Server
+func (this *Server) Start() {
+	for _, packetConn := range this.packetConns {
+		if err := packetConn.listen(); err != nil {
+			panic(err)
+		}
+	}
+
+	sw := protocol.WaitGroupWrapper{}
+	for i := range this.loops {
+		sw.AddAndRun(this.loops[i].Run)
+	}
+	sw.Wait()
+}
+
+// Stop 关闭所有 socket 和 loop
+func (this *Server) Stop() {
+	if this.stopped.Set(true) {
+		return
+	}
+	for _, packetConn := range this.packetConns {
+		packetConn.close()
+	}
+	for _, loop := range this.loops {
+		if err := loop.Stop(); err != nil {
+			this.logger.Error("stop loop failed", log.Loop(loop.SequenceID), log.Err(err))
+		}
+	}
+}
+
+// PacketConns 每个 loop 对应的 socket
+func (this *Server) PacketConns() []*PacketConn {
+	return this.packetConns
+}
+
+// release New 失败时释放已经创建的资源, 这时 loop 还没有运行
+func (this *Server) release() {
+	for _, packetConn := range this.packetConns {
+		_ = packetConn.closeFd()
+	}
+	for _, loop := range this.loops {
+		_ = loop.Close()
+	}
+}
@@ -0,0 +1,246 @@
+package worker
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/zput/zput_net_golang/net/log"
+	"github.com/zput/zput_net_golang/net/protocol"
+)
+
+// DefaultQueueLength 没有指定时整个 Pool 最多排队的任务数
+const DefaultQueueLength = 1024
+
+// mailboxBatch 一个 Mailbox 连续执行的任务数, 之后让出 worker, 避免一个连接占住 worker
+const mailboxBatch = 16
+
+var (
+	// ErrQueueFull 排队的任务数达到上限
+	ErrQueueFull = errors.New("worker queue is full")
+	// ErrPoolStopped Pool 已经停止
+	ErrPoolStopped = errors.New("worker pool is stopped")
+)
+
+// Pool 固定数量的协程执行任务; 任务通过 Mailbox 提交, 同一个 Mailbox 的任务按提交顺序依次执行
+type Pool struct {
+	size int
+	// 每个排队的任务占一个, 执行完归还
+	slots chan struct{}
+	// 有任务待执行的 Mailbox; 每个 Mailbox 至少占一个 slot, 所以容量为 queueLength 时发送不会阻塞
+	ready chan *Mailbox
+	quit  chan struct{}
+
+	stopped protocol.Bool
+	wg      protocol.WaitGroupWrapper
+	logger  *log.FieldLogger
+}
+
+// New size <= 0 时为 CPU 数, queueLength <= 0 时为 DefaultQueueLength
+func New(size, queueLength int) *Pool {
+	if size <= 0 {
+		size = runtime.NumCPU()
+	}
+	if queueLength <= 0 {
+		queueLength = DefaultQueueLength
+	}
+	return &Pool{
+		size:   size,
+		slots:  make(chan struct{}, queueLength),
+		ready:  make(chan *Mailbox, queueLength),
+		quit:   make(chan struct{}),
+		logger: log.Default(),
+	}
+}
+
+// SetLogger 任务 panic 时输出日志, 需要在 Start 之前调用
+func (this *Pool) SetLogger(logger *log.FieldLogger) {
+	this.logger = logger
+}
+
+// Start 启动 worker 协程
+func (this *Pool) Start() {
+	for i := 0; i < this.size; i++ {
+		this.wg.AddAndRun(this.work)
+	}
+}
+
+// Stop 等待正在执行的任务结束, 还在排队的任务不再执行
+func (this *Pool) Stop() {
+	if this.stopped.Set(true) {
+		return
+	}
+	close(this.quit)
+	this.wg.Wait()
+}
+
+// Size worker 协程数
+func (this *Pool) Size() int {
+	return this.size
+}
+
+// Pending 排队和正在执行的任务数
+func (this *Pool) Pending() int {
+	return len(this.slots)
+}
+
+// NewMailbox 一个连接一个, 连接关闭时调用 Mailbox.Close
+func (this *Pool) NewMailbox() *Mailbox {
+	return &Mailbox{pool: this}
+}
+
+func (this *Pool) work() {
+	for {
+		select {
+		case m := <-this.ready:
+			m.run()
+		case <-this.quit:
+			return
+		}
+	}
+}
+
+func (this *Pool) acquire(block bool) error {
+	if this.stopped.Get() {
+		return ErrPoolStopped
+	}
+	if !block {
+		select {
+		case this.slots <- struct{}{}:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	}
+	select {
+	case this.slots <- struct{}{}:
+		return nil
+	case <-this.quit:
+		return ErrPoolStopped
+	}
+}
+
+func (this *Pool) release(n int) {
+	for i := 0; i < n; i++ {
+		<-this.slots
+	}
+}
+
+func (this *Pool) runTask(task func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			this.logger.Error("worker task panic", log.F("panic", r))
+		}
+	}()
+	task()
+}
+
+// Mailbox 保证同一个连接的任务按顺序执行, 同一时刻最多在一个 worker 上
+type Mailbox struct {
+	pool *Pool
+
+	mutex     sync.Mutex
+	tasks     []func()
+	scheduled bool
+	closed    bool
+	// 排队和正在执行的任务数
+	pending int
+	// pending 降到 0 时在 worker 协程中回调
+	idleCallback func()
+}
+
+// SetIdleCallback 排队和正在执行的任务全部完成时在 worker 协程中回调 cb, Close 之后不再回调; 需要在 Submit 之前调用
+func (this *Mailbox) SetIdleCallback(cb func()) {
+	this.idleCallback = cb
+}
+
+// Submit 提交任务, 排队的任务数达到上限时返回 ErrQueueFull; block 为 true 时等待有空位
+func (this *Mailbox) Submit(task func(), block bool) error {
+	if err := this.pool.acquire(block); err != nil {
+		return err
+	}
+
+	this.mutex.Lock()
+	if this.closed {
+		this.mutex.Unlock()
+		this.pool.release(1)
+		return nil
+	}
+	this.tasks = append(this.tasks, task)
+	this.pending++
+	schedule := !this.scheduled
+	this.scheduled = true
+	this.mutex.Unlock()
+
+	if schedule {
+		this.pool.ready <- this
+	}
+	return nil
+}
+
+// Close 丢弃还没执行的任务, 之后提交的任务也直接丢弃; 正在执行的任务不受影响
+func (this *Mailbox) Close() {
+	this.mutex.Lock()
+	this.closed = true
+	this.mutex.Unlock()
+}
+
+// Len 还没执行的任务数
+func (this *Mailbox) Len() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return len(this.tasks)
+}
+
+// Idle 没有排队的任务, 也没有正在执行的任务
+func (this *Mailbox) Idle() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.pending == 0
+}
+
+func (this *Mailbox) run() {
+	for i := 0; i < mailboxBatch; i++ {
+		this.mutex.Lock()
+		if this.closed {
+			// 排队中的任务在这里丢弃, 在 ready 中的 Mailbox 总是占着 slot
+			dropped := len(this.tasks)
+			this.tasks = nil
+			this.pending -= dropped
+			this.scheduled = false
+			this.mutex.Unlock()
+			this.pool.release(dropped)
+			return
+		}
+		if len(this.tasks) == 0 {
+			this.scheduled = false
+			this.mutex.Unlock()
+			return
+		}
+		task := this.tasks[0]
+		this.tasks[0] = nil
+		this.tasks = this.tasks[1:]
+		this.mutex.Unlock()
+
+		this.pool.runTask(task)
+		this.pool.release(1)
+
+		this.mutex.Lock()
+		this.pending--
+		idle := this.pending == 0 && !this.closed
+		this.mutex.Unlock()
+		if idle && this.idleCallback != nil {
+			this.idleCallback()
+		}
+	}
+
+	// 还有任务, 排到队尾让其它连接先执行
+	this.mutex.Lock()
+	if len(this.tasks) == 0 {
+		this.scheduled = false
+		this.mutex.Unlock()
+		return
+	}
+	this.mutex.Unlock()
+	this.pool.ready <- this
+}
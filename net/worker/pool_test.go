@@ -0,0 +1,169 @@
+package worker
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMailboxOrder(t *testing.T) {
+	pool := New(4, 0)
+	pool.Start()
+	defer pool.Stop()
+
+	const (
+		mailboxes = 8
+		tasks     = 200
+	)
+	var wg sync.WaitGroup
+	results := make([][]int, mailboxes)
+	for i := 0; i < mailboxes; i++ {
+		m := pool.NewMailbox()
+		for j := 0; j < tasks; j++ {
+			i, j := i, j
+			wg.Add(1)
+			if err := m.Submit(func() {
+				results[i] = append(results[i], j)
+				wg.Done()
+			}, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		if len(result) != tasks {
+			t.Fatalf("mailbox %d expect %d tasks, get %d", i, tasks, len(result))
+		}
+		for j, v := range result {
+			if v != j {
+				t.Fatalf("mailbox %d expect task %d at %d, get %d", i, j, j, v)
+			}
+		}
+	}
+	if pool.Pending() != 0 {
+		t.Fatalf("expect no pending task, get %d", pool.Pending())
+	}
+}
+
+func TestPoolQueueFull(t *testing.T) {
+	pool := New(1, 2)
+	pool.Start()
+	defer pool.Stop()
+
+	release := make(chan struct{})
+	m := pool.NewMailbox()
+	for i := 0; i < 2; i++ {
+		if err := m.Submit(func() { <-release }, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Submit(func() {}, false); err != ErrQueueFull {
+		t.Fatalf("expect ErrQueueFull, get %v", err)
+	}
+
+	// 阻塞提交等到前面的任务执行完
+	done := make(chan struct{})
+	go func() {
+		_ = m.Submit(func() { close(done) }, true)
+	}()
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("blocked submit not run")
+	}
+}
+
+func TestMailboxClose(t *testing.T) {
+	pool := New(1, 4)
+	pool.Start()
+	defer pool.Stop()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	m := pool.NewMailbox()
+	_ = m.Submit(func() {
+		close(started)
+		<-release
+	}, false)
+	<-started
+
+	ran := false
+	_ = m.Submit(func() { ran = true }, false)
+	m.Close()
+	close(release)
+
+	// 丢弃的任务归还了排队名额
+	deadline := time.Now().Add(time.Second * 5)
+	for pool.Pending() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if ran || pool.Pending() != 0 {
+		t.Fatalf("expect queued task dropped, ran %v, pending %d", ran, pool.Pending())
+	}
+	if err := m.Submit(func() { ran = true }, false); err != nil || ran {
+		t.Fatalf("expect task dropped after close, get %v", err)
+	}
+}
+
+func TestPoolPanic(t *testing.T) {
+	pool := New(1, 0)
+	pool.Start()
+	defer pool.Stop()
+
+	done := make(chan struct{})
+	m := pool.NewMailbox()
+	_ = m.Submit(func() { panic("boom") }, false)
+	_ = m.Submit(func() { close(done) }, false)
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("worker stopped after panic")
+	}
+}
+
+func TestMailboxIdle(t *testing.T) {
+	pool := New(1, 0)
+	pool.Start()
+	defer pool.Stop()
+
+	m := pool.NewMailbox()
+	idle := make(chan struct{}, 1)
+	m.SetIdleCallback(func() {
+		idle <- struct{}{}
+	})
+	if !m.Idle() {
+		t.Fatal("expect new mailbox idle")
+	}
+
+	// 正在执行的任务也算, 全部执行完才回调一次
+	release := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		if err := m.Submit(func() {
+			<-release
+		}, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond * 20)
+	if m.Idle() || m.Len() != 1 {
+		t.Fatalf("expect one running and one queued, get %d queued", m.Len())
+	}
+	close(release)
+
+	select {
+	case <-idle:
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait idle callback timeout")
+	}
+	if !m.Idle() {
+		t.Fatal("expect mailbox idle")
+	}
+	select {
+	case <-idle:
+		t.Fatal("expect idle callback once")
+	case <-time.After(time.Millisecond * 20):
+	}
+}